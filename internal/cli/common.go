@@ -2,6 +2,8 @@ package cli
 
 import (
 	"errors"
+	"fmt"
+	"path"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -35,8 +37,34 @@ func Init() (*cobra.Command, error) {
 	airlockCmd.PersistentFlags().StringVarP(&configPath, "config", "c", "/etc/airlock/config.toml", "path to configuration file")
 	airlockCmd.PersistentFlags().CountVarP(&verbosity, "verbose", "v", "increase verbosity level")
 
-	cmdGet.AddCommand(cmdGetSlots)
-	cmdEx.AddCommand(cmdGet)
+	cmdReconcile.Flags().BoolVar(&reconcileDryRun, "dry-run", false, "only report changes, without applying them")
+
+	cmdGetSlots.Flags().StringVarP(&getSlotsOutput, "output", "o", "", "output format: json, yaml or table (default human-readable)")
+	cmdGetSlots.Flags().BoolVarP(&getSlotsWatch, "watch", "w", false, "watch for changes, printing updates as they happen")
+
+	cmdGetNodes.Flags().StringVar(&getNodesGroup, "group", "", "only show nodes of this group")
+	cmdGetNodes.Flags().DurationVar(&getNodesNotRebootedFor, "not-rebooted-for", 0, "only show nodes without a completed reboot within this duration (e.g. 720h)")
+
+	cmdPause.Flags().StringVar(&pauseReason, "reason", "", "reason for the pause, reported to refused clients")
+	cmdPause.Flags().DurationVar(&pauseFor, "for", 0, "pause duration (e.g. 2h), after which the group resumes automatically")
+
+	for _, cmd := range []*cobra.Command{cmdLock, cmdRelease} {
+		cmd.Flags().StringVar(&manualGroup, "group", "", "lock group")
+		cmd.Flags().StringVar(&manualID, "id", "", "node ID, or manual lock ID (e.g. ops-<ticket>)")
+		cmd.Flags().StringVar(&manualReason, "reason", "", "reason for the action, recorded in etcd with the lease or release")
+		cmd.Flags().StringVar(&manualBy, "by", defaultOperator(), "operator performing the action, recorded in etcd with the lease or release")
+		cmd.Flags().BoolVarP(&manualYes, "yes", "y", false, "do not ask for confirmation")
+		cmd.Flags().BoolVar(&manualDryRun, "dry-run", false, "only report changes, without applying them")
+		for _, name := range []string{"group", "id", "reason"} {
+			if err := cmd.MarkFlagRequired(name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	cmdLock.Flags().DurationVar(&manualMaxHold, "max-hold", 0, "maximum lock duration (e.g. 4h), defaulting to the group max hold")
+
+	cmdGet.AddCommand(cmdGetSlots, cmdGetNodes)
+	cmdEx.AddCommand(cmdGet, cmdReconcile, cmdPause, cmdResume, cmdLock, cmdRelease)
 	airlockCmd.AddCommand(cmdServe, cmdEx)
 
 	return airlockCmd, nil
@@ -88,6 +116,79 @@ func validateSettings(cfg config.Settings) error {
 	if len(cfg.LockGroups) == 0 {
 		return errors.New("no lock-groups configured")
 	}
+	if cfg.ServiceTLS && (cfg.ServiceCertPath == "" || cfg.ServiceKeyPath == "") {
+		return errors.New("service TLS enabled, but no certificate or key configured")
+	}
+	if (cfg.ServiceClientIDCheck || cfg.ServiceClientGroupCheck) && (!cfg.ServiceTLS || cfg.ServiceClientCAPath == "") {
+		return errors.New("client certificate checks enabled, but service TLS client CA not configured")
+	}
+	for group, groupSettings := range cfg.LockGroups {
+		if groupSettings.SlotsPercent > 100 {
+			return fmt.Errorf("group %q: slots percentage above 100", group)
+		}
+		if groupSettings.MaxSlots > 0 && groupSettings.MinSlots > groupSettings.MaxSlots {
+			return fmt.Errorf("group %q: minimum slots above maximum slots", group)
+		}
+		if _, ok := cfg.LockGroups[groupSettings.Parent]; groupSettings.Parent != "" && !ok {
+			return fmt.Errorf("group %q: unknown parent group %q", group, groupSettings.Parent)
+		}
+	}
+	for group := range cfg.LockGroups {
+		// With known parents, a chain only stops early on a cycle.
+		chain := cfg.GroupChain(group)
+		if root := cfg.LockGroups[chain[len(chain)-1]]; root.Parent != "" {
+			return fmt.Errorf("group %q: cyclic parent groups", group)
+		}
+	}
+	for _, class := range cfg.PriorityClasses {
+		if class.Name == "" {
+			return errors.New("priority class without name")
+		}
+		if err := validatePatterns(class.IDs); err != nil {
+			return fmt.Errorf("priority class %q: %w", class.Name, err)
+		}
+	}
+	for _, weight := range cfg.NodeWeights {
+		if weight.Weight == 0 {
+			return errors.New("node weight must be at least 1")
+		}
+		if err := validatePatterns(weight.IDs); err != nil {
+			return fmt.Errorf("node weight %d: %w", weight.Weight, err)
+		}
+	}
+	for _, membership := range cfg.GroupMemberships {
+		for _, group := range membership.Groups {
+			if _, ok := cfg.LockGroups[group]; !ok {
+				return fmt.Errorf("group membership: unknown group %q", group)
+			}
+		}
+		if err := validatePatterns(membership.IDs); err != nil {
+			return fmt.Errorf("group membership: %w", err)
+		}
+	}
+	if err := cfg.CheckSecrets(); err != nil {
+		return err
+	}
+	if cfg.StatusEnabled && cfg.StatusTLS && (cfg.StatusCertPath == "" || cfg.StatusKeyPath == "") {
+		return errors.New("status TLS enabled, but no certificate or key configured")
+	}
+	if cfg.AdminEnabled && cfg.AdminTLS && (cfg.AdminCertPath == "" || cfg.AdminKeyPath == "") {
+		return errors.New("admin TLS enabled, but no certificate or key configured")
+	}
+	if cfg.AdminEnabled && len(cfg.AdminToken) == 0 && (!cfg.AdminTLS || cfg.AdminClientCAPath == "") {
+		return errors.New("admin service enabled, but neither token nor TLS client CA configured")
+	}
+
+	return nil
+}
+
+// validatePatterns checks that all node ID patterns are well-formed
+func validatePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid ID pattern %q: %w", pattern, err)
+		}
+	}
 
 	return nil
 }
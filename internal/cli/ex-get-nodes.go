@@ -0,0 +1,80 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/coreos/airlock/internal/lock"
+)
+
+var (
+	// cmdGetNodes holds `airlock ex get nodes`
+	cmdGetNodes = &cobra.Command{
+		Use:   "nodes",
+		Short: "Introspect known nodes and their reboot history",
+		RunE:  runGetNodes,
+	}
+
+	getNodesGroup          string
+	getNodesNotRebootedFor time.Duration
+)
+
+// runGetNodes performs live introspection of the node registry.
+func runGetNodes(cmd *cobra.Command, cmdArgs []string) error {
+	if runSettings == nil {
+		return errors.New("nil runSettings")
+	}
+
+	groups := make([]string, 0, len(runSettings.LockGroups))
+	for group := range runSettings.LockGroups {
+		if getNodesGroup == "" || group == getNodesGroup {
+			groups = append(groups, group)
+		}
+	}
+	if len(groups) == 0 {
+		return fmt.Errorf("unknown group %q", getNodesGroup)
+	}
+	sort.Strings(groups)
+
+	client, err := lock.NewClient(runSettings.EtcdEndpoints, runSettings.ClientCertPubPath, runSettings.ClientCertKeyPath, runSettings.EtcdTxnTimeout)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	for _, group := range groups {
+		err := runWithManager(client, group, runSettings.LockGroups[group].EffectiveSlots(0), func(ctx context.Context, manager *lock.Manager) error {
+			nodes, err := manager.Nodes(ctx)
+			if err != nil {
+				return err
+			}
+			printNodesHuman(group, lock.FilterNotRebooted(nodes, time.Now(), getNodesNotRebootedFor))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// printNodesHuman prints node registry details in a human-friendly way.
+func printNodesHuman(group string, nodes []lock.Node) {
+	fmt.Printf("group: %s\n", group)
+	fmt.Printf(" nodes: %d\n", len(nodes))
+	for _, node := range nodes {
+		fmt.Printf(" - %s (%s, last seen %s)\n", node.ID, node.State, node.LastSeen.Format(time.RFC3339))
+		if node.Reboots == 0 {
+			fmt.Printf("   no completed reboot\n")
+			continue
+		}
+		fmt.Printf("   last reboot %s, took %s (%d total)\n", node.LastSteadyState.Format(time.RFC3339), time.Duration(node.LastRebootSecs)*time.Second, node.Reboots)
+	}
+	fmt.Printf("\n---\n")
+}
@@ -1,13 +1,25 @@
 package cli
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
 
+	"github.com/coreos/airlock/internal/config"
 	"github.com/coreos/airlock/internal/lock"
+	"github.com/coreos/airlock/internal/maintenance"
 )
 
 var (
@@ -17,47 +29,292 @@ var (
 		Short: "Introspect groups/slots state",
 		RunE:  runGetSlots,
 	}
+
+	getSlotsOutput string
+	getSlotsWatch  bool
 )
 
+// groupSlots holds the slots state of a single group, for machine-readable output.
+type groupSlots struct {
+	Group string `json:"group"`
+	// MaintenanceWindowOpen is whether locks can be granted per the maintenance windows.
+	MaintenanceWindowOpen bool `json:"maintenance_window_open"`
+	// NextOpening is the next maintenance window opening, if closed.
+	NextOpening *time.Time `json:"next_opening,omitempty"`
+	// Blackout is the active blackout, if any.
+	Blackout  *maintenance.Blackout `json:"blackout,omitempty"`
+	Semaphore *lock.Semaphore       `json:"semaphore"`
+}
+
+// newGroupSlots returns the slots state of a group with semaphore `sem` at time `now`.
+func newGroupSlots(group string, sem *lock.Semaphore, now time.Time) groupSlots {
+	groupSettings := runSettings.LockGroups[group]
+	state := groupSlots{
+		Group:                 group,
+		MaintenanceWindowOpen: groupSettings.Maintenance.Open(now),
+		Blackout:              groupSettings.ActiveBlackout(now),
+		Semaphore:             sem,
+	}
+	if !state.MaintenanceWindowOpen {
+		next := groupSettings.Maintenance.NextOpening(now)
+		state.NextOpening = &next
+	}
+	return state
+}
+
 // runGetSlots performs live introspection of groups/slots.
 func runGetSlots(cmd *cobra.Command, cmdArgs []string) error {
 	if runSettings == nil {
 		return errors.New("nil runSettings")
 	}
+	switch getSlotsOutput {
+	case "", "json", "yaml", "table":
+	default:
+		return fmt.Errorf("unsupported output format %q", getSlotsOutput)
+	}
 
-	for group, maxSlots := range runSettings.LockGroups {
-		if group == "" {
-			continue
+	groups := make([]string, 0, len(runSettings.LockGroups))
+	for group := range runSettings.LockGroups {
+		if group != "" {
+			groups = append(groups, group)
+		}
+	}
+	sort.Strings(groups)
+
+	return withGroupManagers(groups, func(managers []*lock.Manager) error {
+		if getSlotsWatch {
+			return watchSlots(managers, groups)
+		}
+
+		sems, err := fetchSemaphores(managers)
+		if err != nil {
+			return err
+		}
+		states := make([]groupSlots, 0, len(groups))
+		for i, group := range groups {
+			states = append(states, newGroupSlots(group, sems[i], time.Now()))
 		}
+		return printSlots(states)
+	})
+}
+
+// watchSlots prints groups/slots state, then updates as they are written to etcd,
+// until interrupted.
+//
+// JSON and YAML outputs stream each updated group, the table is reprinted in full.
+func watchSlots(managers []*lock.Manager, groups []string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-		ctx, cancel := context.WithTimeout(context.Background(), runSettings.EtcdTxnTimeout)
-		defer cancel()
+	states := make([]groupSlots, len(groups))
+	index := make(map[string]int, len(groups))
+	for i, group := range groups {
+		states[i].Group = group
+		index[group] = i
+	}
+	initial := 0
 
-		manager, err := lock.NewManager(ctx, runSettings.EtcdEndpoints, runSettings.ClientCertPubPath, runSettings.ClientCertKeyPath, runSettings.EtcdTxnTimeout, group, maxSlots)
+	err := lock.Watch(ctx, managers, func(group string, sem *lock.Semaphore) error {
+		state := newGroupSlots(group, sem, time.Now())
+		states[index[group]] = state
+		switch getSlotsOutput {
+		case "json":
+			return printJSONLine(state)
+		case "yaml":
+			return printYAMLDocument(state)
+		case "table":
+			// Only print once all groups have been fetched.
+			if initial < len(groups) {
+				initial++
+				if initial < len(groups) {
+					return nil
+				}
+			} else {
+				fmt.Printf("\n%s\n", time.Now().Format(time.RFC3339))
+			}
+			// Refresh the maintenance state of all groups, not only the updated one.
+			now := time.Now()
+			for i := range states {
+				states[i] = newGroupSlots(states[i].Group, states[i].Semaphore, now)
+			}
+			return printSlotsTable(states)
+		default:
+			printHumanShort(group, runSettings.LockGroups[group], sem)
+			return nil
+		}
+	})
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
+
+// printSlots prints groups/slots state in the configured output format.
+func printSlots(states []groupSlots) error {
+	switch getSlotsOutput {
+	case "json":
+		out, err := json.MarshalIndent(states, "", "  ")
 		if err != nil {
 			return err
 		}
-		semaphore, err := manager.FetchSemaphore(ctx)
+		fmt.Println(string(out))
+		return nil
+	case "yaml":
+		out, err := marshalYAML(states)
 		if err != nil {
 			return err
 		}
-		printHumanShort(group, semaphore)
+		fmt.Print(string(out))
+		return nil
+	case "table":
+		return printSlotsTable(states)
+	default:
+		for _, state := range states {
+			printHumanShort(state.Group, runSettings.LockGroups[state.Group], state.Semaphore)
+		}
+		return nil
 	}
+}
 
+// printSlotsTable prints groups/slots state as a table, one group per row.
+func printSlotsTable(states []groupSlots) error {
+	now := time.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "GROUP\tSLOTS\tUSED\tHOLDERS\tWAITERS\tPAUSED\tWINDOW\tBLACKOUT")
+	for _, state := range states {
+		sem := state.Semaphore
+		holders := "-"
+		if len(sem.Holders) > 0 {
+			holders = strings.Join(sem.Holders, ",")
+		}
+		paused := "no"
+		if pause := sem.ActivePause(now); pause != nil {
+			paused = "yes"
+			if pause.Reason != "" {
+				paused = fmt.Sprintf("yes (%s)", pause.Reason)
+			}
+		}
+		window := "open"
+		if !state.MaintenanceWindowOpen {
+			window = "closed"
+			if state.NextOpening != nil {
+				window = fmt.Sprintf("closed (until %s)", state.NextOpening.Format(time.RFC3339))
+			}
+		}
+		blackout := "-"
+		if state.Blackout != nil {
+			blackout = fmt.Sprintf("%s (until %s)", state.Blackout.Name, state.Blackout.End.Format(time.RFC3339))
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%d\t%s\t%s\t%s\n", state.Group, sem.TotalSlots, sem.UsedWeight(), holders, len(sem.Waiters), paused, window, blackout)
+	}
+	return w.Flush()
+}
+
+// printJSONLine prints a single value as a line of JSON.
+func printJSONLine(value interface{}) error {
+	out, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
+
+// printYAMLDocument prints a single value as a YAML document.
+func printYAMLDocument(value interface{}) error {
+	out, err := marshalYAML(value)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("---\n%s", out)
 	return nil
 }
 
+// marshalYAML encodes a value as YAML, with the same field names and order as
+// its JSON encoding.
+func marshalYAML(value interface{}) ([]byte, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	// JSON is valid YAML, and decoding it as a node keeps the field order.
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return nil, err
+	}
+	clearNodeStyle(&node)
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&node); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// clearNodeStyle resets the JSON flow and quoting styles of a YAML node tree,
+// so that it is encoded in block style.
+func clearNodeStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		clearNodeStyle(child)
+	}
+}
+
 // printHumanShort prints groups/slots details in a short, human-friendly way.
-func printHumanShort(group string, semaphore *lock.Semaphore) {
+func printHumanShort(group string, groupSettings config.GroupSettings, semaphore *lock.Semaphore) {
 	if group == "" || semaphore == nil {
 		return
 	}
 
 	fmt.Printf("group: %s\n", group)
+	if groupSettings.Parent != "" {
+		fmt.Printf(" parent group: %s\n", groupSettings.Parent)
+	}
+	if schedule := groupSettings.Maintenance; schedule != nil {
+		now := time.Now()
+		if schedule.Open(now) {
+			fmt.Printf(" maintenance window: open\n")
+		} else {
+			fmt.Printf(" maintenance window: closed (next opening at %s)\n", schedule.NextOpening(now).Format(time.RFC3339))
+		}
+	}
+	if blackout := groupSettings.ActiveBlackout(time.Now()); blackout != nil {
+		fmt.Printf(" blackout: %s (until %s)\n", blackout.Name, blackout.End.Format(time.RFC3339))
+	}
 	fmt.Printf(" semaphore slots: %d\n", semaphore.TotalSlots)
+	if pause := semaphore.ActivePause(time.Now()); pause != nil {
+		fmt.Printf(" paused: since %s", pause.Since.Format(time.RFC3339))
+		if pause.Until != nil {
+			fmt.Printf(", until %s", pause.Until.Format(time.RFC3339))
+		}
+		if pause.Reason != "" {
+			fmt.Printf(" (%s)", pause.Reason)
+		}
+		fmt.Printf("\n")
+	}
+	fmt.Printf(" weight used: %d/%d\n", semaphore.UsedWeight(), semaphore.TotalSlots)
 	fmt.Printf(" lock owners:\n")
 	for _, owner := range semaphore.Holders {
-		fmt.Printf(" - %s\n", owner)
+		lease, ok := semaphore.Leases[owner]
+		if !ok {
+			fmt.Printf(" - %s\n", owner)
+			continue
+		}
+		fmt.Printf(" - %s (weight %d, since %s", owner, lease.SlotWeight(), lease.AcquiredAt.Format(time.RFC3339))
+		if lease.By != "" {
+			fmt.Printf(", locked by %s: %s", lease.By, lease.Reason)
+		}
+		fmt.Printf(")\n")
+	}
+	if len(semaphore.Waiters) > 0 {
+		fmt.Printf(" waiting queue:\n")
+	}
+	for i, waiter := range semaphore.RankedWaiters(time.Now(), runSettings.PriorityAging) {
+		fmt.Printf(" %d. %s (priority %d, since %s, last seen %s)\n", i+1, waiter.ID, waiter.Priority, waiter.EnqueuedAt.Format(time.RFC3339), waiter.LastSeen.Format(time.RFC3339))
 	}
 	fmt.Printf("\n---\n")
 }
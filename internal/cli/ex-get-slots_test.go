@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/coreos/airlock/internal/lock"
+)
+
+func TestSlotsOutputOrder(t *testing.T) {
+	acquired := time.Date(2021, time.March, 1, 12, 0, 0, 0, time.UTC)
+	states := []groupSlots{
+		{
+			Group:                 "controllers",
+			MaintenanceWindowOpen: true,
+			Semaphore:             &lock.Semaphore{TotalSlots: 1, Holders: []string{}},
+		},
+		{
+			Group:                 "workers",
+			MaintenanceWindowOpen: true,
+			Semaphore: &lock.Semaphore{
+				TotalSlots: 2,
+				Holders:    []string{"a", "b"},
+				Leases: map[string]lock.Lease{
+					"b": {AcquiredAt: acquired},
+					"a": {AcquiredAt: acquired, MaxHoldSecs: 60},
+				},
+			},
+		},
+	}
+
+	expectedJSON := `[{"group":"controllers","maintenance_window_open":true,"semaphore":{"total_slots":1,"holders":[]}},` +
+		`{"group":"workers","maintenance_window_open":true,"semaphore":{"total_slots":2,"holders":["a","b"],` +
+		`"leases":{"a":{"acquired_at":"2021-03-01T12:00:00Z","max_hold_secs":60},"b":{"acquired_at":"2021-03-01T12:00:00Z"}}}}]`
+	// YAML fields are in the same order as JSON fields, not sorted.
+	expectedYAML := `- group: controllers
+  maintenance_window_open: true
+  semaphore:
+    total_slots: 1
+    holders: []
+- group: workers
+  maintenance_window_open: true
+  semaphore:
+    total_slots: 2
+    holders:
+      - a
+      - b
+    leases:
+      a:
+        acquired_at: "2021-03-01T12:00:00Z"
+        max_hold_secs: 60
+      b:
+        acquired_at: "2021-03-01T12:00:00Z"
+`
+
+	// Output is stable across runs, despite map iteration order.
+	for i := 0; i < 10; i++ {
+		out, err := json.Marshal(states)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != expectedJSON {
+			t.Fatalf("unexpected JSON output:\n%s", out)
+		}
+
+		out, err = marshalYAML(states)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != expectedYAML {
+			t.Fatalf("unexpected YAML output:\n%s", out)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/coreos/airlock/internal/lock"
+)
+
+var (
+	// cmdLock holds `airlock ex lock`
+	cmdLock = &cobra.Command{
+		Use:   "lock",
+		Short: "Take a manual maintenance lock on behalf of an ID",
+		Args:  cobra.NoArgs,
+		RunE:  runLock,
+	}
+	// cmdRelease holds `airlock ex release`
+	cmdRelease = &cobra.Command{
+		Use:   "release",
+		Short: "Force-release the lock held by a node or a manual lock",
+		Args:  cobra.NoArgs,
+		RunE:  runRelease,
+	}
+
+	manualGroup   string
+	manualID      string
+	manualReason  string
+	manualBy      string
+	manualMaxHold time.Duration
+	manualYes     bool
+	manualDryRun  bool
+)
+
+// runLock locks the semaphores of a group (and of all groups locked with it) for an ID.
+func runLock(cmd *cobra.Command, cmdArgs []string) error {
+	if runSettings == nil {
+		return errors.New("nil runSettings")
+	}
+	groupSettings, ok := runSettings.LockGroups[manualGroup]
+	if !ok {
+		return fmt.Errorf("unknown group %q", manualGroup)
+	}
+
+	maxHold := manualMaxHold
+	if maxHold == 0 {
+		maxHold = groupSettings.MaxHold
+	}
+	opts := lock.LockOptions{
+		MaxHold: maxHold,
+		Weight:  runSettings.WeightFor(manualID),
+		By:      manualBy,
+		Reason:  manualReason,
+	}
+	groups := runSettings.GroupsFor(manualID, manualGroup)
+
+	return withGroupManagers(groups, func(managers []*lock.Manager) error {
+		fmt.Printf("lock %s in groups %s (weight %d", manualID, strings.Join(groups, ", "), opts.Weight)
+		if maxHold > 0 {
+			fmt.Printf(", max hold %s", maxHold)
+		}
+		fmt.Printf(") by %s: %s\n", manualBy, manualReason)
+
+		if manualDryRun {
+			sems, err := fetchSemaphores(managers)
+			if err != nil {
+				return err
+			}
+			for i, sem := range sems {
+				// Fetched semaphores are private copies, locking them is only local.
+				held, err := sem.RecursiveLockWithOptions(manualID, opts)
+				switch {
+				case err != nil:
+					fmt.Printf("group %s: would fail: %s\n", groups[i], err)
+				case held:
+					fmt.Printf("group %s: already held, unchanged\n", groups[i])
+				default:
+					fmt.Printf("group %s: would lock, %d/%d slots used\n", groups[i], sem.UsedWeight(), sem.TotalSlots)
+				}
+			}
+			fmt.Println("dry run, no changes applied")
+			return nil
+		}
+		if !confirm("Take this lock?") {
+			fmt.Println("aborted, no changes applied")
+			return nil
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), runSettings.EtcdTxnTimeout)
+		defer cancel()
+		sems, err := lock.LockAll(ctx, managers, manualID, opts)
+		if err != nil {
+			return err
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"groups": groups,
+			"id":     manualID,
+			"by":     manualBy,
+			"reason": manualReason,
+		}).Warn("manual lock taken")
+		for i, sem := range sems {
+			fmt.Printf("group %s: locked, %d/%d slots used\n", groups[i], sem.UsedWeight(), sem.TotalSlots)
+		}
+		return nil
+	})
+}
+
+// runRelease removes an ID from the holders of a group (and of all groups locked with it).
+func runRelease(cmd *cobra.Command, cmdArgs []string) error {
+	if runSettings == nil {
+		return errors.New("nil runSettings")
+	}
+	if _, ok := runSettings.LockGroups[manualGroup]; !ok {
+		return fmt.Errorf("unknown group %q", manualGroup)
+	}
+	groups := runSettings.GroupsFor(manualID, manualGroup)
+
+	return withGroupManagers(groups, func(managers []*lock.Manager) error {
+		sems, err := fetchSemaphores(managers)
+		if err != nil {
+			return err
+		}
+		// releases holds the release records of groups in which the lock is held.
+		releases := make(map[int]lock.Release, len(sems))
+		for i, sem := range sems {
+			if sem.NodeState(manualID) != lock.NodeLocked {
+				continue
+			}
+			release := lock.Release{
+				By:     manualBy,
+				Reason: manualReason,
+			}
+			fmt.Printf("group %s: release %s", groups[i], manualID)
+			// Holders written by older versions have no lease details.
+			if lease, ok := sem.Leases[manualID]; ok {
+				release.Lease = &lease
+				fmt.Printf(" (since %s", lease.AcquiredAt.Format(time.RFC3339))
+				if lease.By != "" {
+					fmt.Printf(", locked by %s: %s", lease.By, lease.Reason)
+				}
+				fmt.Printf(")")
+			}
+			fmt.Printf("\n")
+			releases[i] = release
+		}
+		if len(releases) == 0 {
+			fmt.Printf("%s holds no lock in groups %s, nothing to release\n", manualID, strings.Join(groups, ", "))
+			return printLastRelease(managers[0], groups[0])
+		}
+
+		if manualDryRun {
+			fmt.Println("dry run, no changes applied")
+			return nil
+		}
+		if !confirm(fmt.Sprintf("Release the lock held by %s?", manualID)) {
+			fmt.Println("aborted, no changes applied")
+			return nil
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), runSettings.EtcdTxnTimeout)
+		defer cancel()
+		sems, _, err = lock.UnlockAll(ctx, managers, manualID)
+		if err != nil {
+			return err
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"groups": groups,
+			"id":     manualID,
+			"by":     manualBy,
+			"reason": manualReason,
+		}).Warn("lock force-released")
+		for i, sem := range sems {
+			fmt.Printf("group %s: released, %d/%d slots used\n", groups[i], sem.UsedWeight(), sem.TotalSlots)
+		}
+
+		now := time.Now().UTC()
+		for i, release := range releases {
+			release.ReleasedAt = now
+			if err := managers[i].RecordRelease(ctx, manualID, release); err != nil {
+				return fmt.Errorf("lock released, but failed to record it in group %q: %w", groups[i], err)
+			}
+		}
+		return nil
+	})
+}
+
+// printLastRelease prints the record of the last lock force-released from
+// `manualID` in `group`, if any.
+func printLastRelease(manager *lock.Manager, group string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), runSettings.EtcdTxnTimeout)
+	defer cancel()
+
+	release, err := manager.LastRelease(ctx, manualID)
+	if err != nil || release == nil {
+		return err
+	}
+	fmt.Printf("group %s: last released at %s by %s: %s\n", group, release.ReleasedAt.Format(time.RFC3339), release.By, release.Reason)
+	return nil
+}
+
+// withGroupManagers runs `fn` with initialized lock managers for configured
+// groups, sharing a single etcd client.
+func withGroupManagers(groups []string, fn func([]*lock.Manager) error) error {
+	client, err := lock.NewClient(runSettings.EtcdEndpoints, runSettings.ClientCertPubPath, runSettings.ClientCertKeyPath, runSettings.EtcdTxnTimeout)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	managers := make([]*lock.Manager, 0, len(groups))
+	for _, group := range groups {
+		err := runWithManager(client, group, runSettings.LockGroups[group].EffectiveSlots(0), func(ctx context.Context, manager *lock.Manager) error {
+			managers = append(managers, manager)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("group %q: %w", group, err)
+		}
+	}
+
+	return fn(managers)
+}
+
+// fetchSemaphores returns the current semaphores of all `managers`.
+func fetchSemaphores(managers []*lock.Manager) ([]*lock.Semaphore, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), runSettings.EtcdTxnTimeout)
+	defer cancel()
+
+	sems := make([]*lock.Semaphore, 0, len(managers))
+	for _, manager := range managers {
+		sem, err := manager.FetchSemaphore(ctx)
+		if err != nil {
+			return nil, err
+		}
+		sems = append(sems, sem)
+	}
+	return sems, nil
+}
+
+// confirm asks for interactive confirmation on stdin, unless `--yes` is set.
+func confirm(prompt string) bool {
+	if manualYes {
+		return true
+	}
+
+	fmt.Printf("%s [y/N] ", prompt)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && answer == "" {
+		fmt.Println()
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// defaultOperator returns the local operator identity, as "user@host".
+func defaultOperator() string {
+	name := "unknown"
+	if current, err := user.Current(); err == nil {
+		name = current.Username
+	}
+	if host, err := os.Hostname(); err == nil {
+		name = fmt.Sprintf("%s@%s", name, host)
+	}
+	return name
+}
@@ -0,0 +1,105 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/coreos/airlock/internal/config"
+	"github.com/coreos/airlock/internal/lock"
+)
+
+var (
+	// cmdReconcile holds `airlock ex reconcile`
+	cmdReconcile = &cobra.Command{
+		Use:   "reconcile",
+		Short: "Reconcile semaphore slots in etcd with configuration",
+		RunE:  runReconcile,
+	}
+
+	reconcileDryRun bool
+)
+
+// runReconcile updates semaphore slots in etcd to match the configuration.
+func runReconcile(cmd *cobra.Command, cmdArgs []string) error {
+	if runSettings == nil {
+		return errors.New("nil runSettings")
+	}
+
+	client, err := lock.NewClient(runSettings.EtcdEndpoints, runSettings.ClientCertPubPath, runSettings.ClientCertKeyPath, runSettings.EtcdTxnTimeout)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	groups := make([]string, 0, len(runSettings.LockGroups))
+	for group := range runSettings.LockGroups {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	for _, group := range groups {
+		if err := reconcileGroup(client, group, runSettings.LockGroups[group]); err != nil {
+			return fmt.Errorf("group %q: %w", group, err)
+		}
+	}
+
+	return nil
+}
+
+// reconcileGroup reconciles semaphore slots for a single group, printing any change.
+//
+// Percentage-based slots are computed from the currently known group members.
+func reconcileGroup(client *clientv3.Client, group string, groupSettings config.GroupSettings) error {
+	ctx, cancel := context.WithTimeout(context.Background(), runSettings.EtcdTxnTimeout)
+	defer cancel()
+
+	manager, err := lock.NewManager(client, group, groupSettings.EffectiveSlots(0))
+	if err != nil {
+		return err
+	}
+	if err := manager.EnsureInit(ctx); err != nil {
+		return err
+	}
+
+	slots := groupSettings.Slots
+	if groupSettings.SlotsPercent > 0 {
+		members, err := manager.Members(ctx)
+		if err != nil {
+			return err
+		}
+		known := len(members) - len(lock.StaleMembers(members, time.Now(), runSettings.MemberTimeout))
+		slots = groupSettings.EffectiveSlots(uint64(known))
+		fmt.Printf("group %s: %d%% of %d known members\n", group, groupSettings.SlotsPercent, known)
+	}
+
+	semaphore, err := manager.FetchSemaphore(ctx)
+	if err != nil {
+		return err
+	}
+	if semaphore.TotalSlots == slots {
+		fmt.Printf("group %s: %d slots, unchanged\n", group, slots)
+		return nil
+	}
+	if reconcileDryRun {
+		fmt.Printf("group %s: %d -> %d slots (dry-run)\n", group, semaphore.TotalSlots, slots)
+		return nil
+	}
+
+	semaphore, previous, err := manager.ReconcileSlots(ctx, slots)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("group %s: %d -> %d slots\n", group, previous, semaphore.TotalSlots)
+	// A single holder may be heavier than the whole group, as on an idle group.
+	if semaphore.UsedWeight() > semaphore.TotalSlots && len(semaphore.Holders) > 1 {
+		fmt.Printf(" warning: %d holders (weight %d) kept above capacity\n", len(semaphore.Holders), semaphore.UsedWeight())
+	}
+
+	return nil
+}
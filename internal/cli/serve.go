@@ -8,10 +8,12 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 
+	"github.com/coreos/airlock/internal/config"
 	"github.com/coreos/airlock/internal/server"
 	"github.com/coreos/airlock/internal/status"
 )
@@ -32,10 +34,22 @@ func runServe(cmd *cobra.Command, cmdArgs []string) error {
 	if runSettings == nil {
 		return errors.New("nil runSettings")
 	}
-	airlock := server.Airlock{Settings: *runSettings}
+
+	// Background tasks context.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	airlock, err := server.NewAirlock(ctx, *runSettings)
+	if err != nil {
+		return err
+	}
+	defer airlock.Close()
+	var services []*http.Server
 
 	stopCh := make(chan os.Signal, 4)
 	signal.Notify(stopCh, os.Interrupt, syscall.SIGTERM)
+	reloadCh := make(chan os.Signal, 1)
+	signal.Notify(reloadCh, syscall.SIGHUP)
 
 	// Status service.
 	if runSettings.StatusEnabled {
@@ -45,16 +59,27 @@ func runServe(cmd *cobra.Command, cmdArgs []string) error {
 
 		statusMux := http.NewServeMux()
 		statusMux.Handle(status.MetricsEndpoint, status.Metrics())
+		statusMux.Handle(status.HealthEndpoint, status.Health())
+		statusMux.Handle(server.ReadinessEndpoint, airlock.Readiness())
+		statusMux.Handle(server.NodesEndpoint, airlock.Nodes())
 		statusService := http.Server{
 			Addr:    fmt.Sprintf("%s:%d", runSettings.StatusAddress, runSettings.StatusPort),
 			Handler: statusMux,
 		}
-		go runService(stopCh, &statusService, airlock)
-		defer statusService.Close()
+		if runSettings.StatusTLS {
+			tlsConfig, err := server.NewTLSConfig(runSettings.StatusCertPath, runSettings.StatusKeyPath, runSettings.StatusClientCAPath)
+			if err != nil {
+				return err
+			}
+			statusService.TLSConfig = tlsConfig
+		}
+		go runService(stopCh, &statusService)
+		services = append(services, &statusService)
 
 		logrus.WithFields(logrus.Fields{
 			"address": runSettings.StatusAddress,
 			"port":    runSettings.StatusPort,
+			"tls":     runSettings.StatusTLS,
 		}).Info("status service")
 	} else {
 		logrus.Warn("status service disabled")
@@ -68,25 +93,146 @@ func runServe(cmd *cobra.Command, cmdArgs []string) error {
 		Addr:    fmt.Sprintf("%s:%d", runSettings.ServiceAddress, runSettings.ServicePort),
 		Handler: serviceMux,
 	}
+	if runSettings.ServiceTLS {
+		tlsConfig, err := server.NewTLSConfig(runSettings.ServiceCertPath, runSettings.ServiceKeyPath, runSettings.ServiceClientCAPath)
+		if err != nil {
+			return err
+		}
+		mainService.TLSConfig = tlsConfig
+	}
 	logrus.WithFields(logrus.Fields{
 		"address": runSettings.ServiceAddress,
 		"port":    runSettings.ServicePort,
+		"tls":     runSettings.ServiceTLS,
 	}).Info("main service")
-	go runService(stopCh, &mainService, airlock)
-	defer mainService.Close()
+	go runService(stopCh, &mainService)
+	// Main service goes first, so that in-flight lock transactions are drained
+	// while the status service still reports on them.
+	services = append([]*http.Server{&mainService}, services...)
+
+	// Admin service.
+	if runSettings.AdminEnabled {
+		adminMux := http.NewServeMux()
+		adminMux.Handle(server.AdminEndpoint, airlock.Admin())
+		adminMux.Handle(server.AdminEndpoint+"/", airlock.Admin())
+		adminService := http.Server{
+			Addr:    fmt.Sprintf("%s:%d", runSettings.AdminAddress, runSettings.AdminPort),
+			Handler: adminMux,
+		}
+		if runSettings.AdminTLS {
+			tlsConfig, err := server.NewTLSConfig(runSettings.AdminCertPath, runSettings.AdminKeyPath, runSettings.AdminClientCAPath)
+			if err != nil {
+				return err
+			}
+			adminService.TLSConfig = tlsConfig
+		}
+		logrus.WithFields(logrus.Fields{
+			"address": runSettings.AdminAddress,
+			"port":    runSettings.AdminPort,
+			"tls":     runSettings.AdminTLS,
+		}).Info("admin service")
+		go runService(stopCh, &adminService)
+		services = append([]*http.Server{services[0], &adminService}, services[1:]...)
+	}
 
 	// Background consistency checker.
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	go airlock.RunConsistencyChecker(ctx)
+	checkerDone := make(chan struct{})
+	go func() {
+		airlock.RunConsistencyChecker(ctx)
+		close(checkerDone)
+	}()
 
-	<-stopCh
+	sig := waitForStop(ctx, stopCh, reloadCh, airlock)
+	logrus.WithFields(logrus.Fields{
+		"signal":    sig,
+		"in_flight": airlock.InFlight(),
+		"timeout":   runSettings.ShutdownTimeout,
+	}).Info("shutting down, draining in-flight requests")
+
+	shutdownServices(services, runSettings.ShutdownTimeout)
+
+	cancel()
+	<-checkerDone
+	logrus.Debug("consistency checker stopped")
+
+	// The etcd client is closed last, by the deferred `airlock.Close()`.
 	return nil
 }
 
-// runService runs an HTTP service
-func runService(stopCh chan os.Signal, service *http.Server, airlock server.Airlock) {
-	if err := service.ListenAndServe(); err != nil {
+// waitForStop blocks until a stop signal is received, reloading the configuration
+// on each reload signal in the meantime.
+func waitForStop(ctx context.Context, stopCh chan os.Signal, reloadCh chan os.Signal, airlock *server.Airlock) os.Signal {
+	for {
+		select {
+		case sig := <-stopCh:
+			return sig
+		case <-reloadCh:
+			logrus.WithFields(logrus.Fields{
+				"path": configPath,
+			}).Info("reloading configuration")
+			if err := reloadSettings(ctx, airlock); err != nil {
+				logrus.WithFields(logrus.Fields{
+					"path":   configPath,
+					"reason": err,
+				}).Error("configuration reload refused, keeping previous configuration")
+			}
+		}
+	}
+}
+
+// reloadSettings parses and validates the configuration file, applying it to
+// the running service only if valid.
+func reloadSettings(ctx context.Context, airlock *server.Airlock) error {
+	cfg, err := config.Parse(configPath)
+	if err != nil {
+		return err
+	}
+	if err := validateSettings(cfg); err != nil {
+		return err
+	}
+	if err := airlock.Reload(ctx, cfg); err != nil {
+		return err
+	}
+	runSettings = &cfg
+
+	return nil
+}
+
+// shutdownServices gracefully shuts down HTTP services in order, forcibly closing
+// connections still active after `timeout`. The timeout is shared by all
+// services, so that shutdown never takes longer overall.
+func shutdownServices(services []*http.Server, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for _, service := range services {
+		if err := service.Shutdown(ctx); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"address": service.Addr,
+				"reason":  err,
+			}).Warn("graceful shutdown timed out, closing connections")
+			service.Close()
+			continue
+		}
+		logrus.WithFields(logrus.Fields{
+			"address": service.Addr,
+		}).Info("service drained")
+	}
+}
+
+// runService runs an HTTP service, over TLS if configured
+func runService(stopCh chan os.Signal, service *http.Server) {
+	var err error
+	if service.TLSConfig != nil {
+		// Certificates are provided by the TLS configuration itself.
+		err = service.ListenAndServeTLS("", "")
+	} else {
+		err = service.ListenAndServe()
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return
+	}
+	if err != nil {
 		logrus.WithFields(logrus.Fields{
 			"reason": err,
 		}).Error("service failure")
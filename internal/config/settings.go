@@ -1,8 +1,20 @@
 package config
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
 	"time"
+
+	"github.com/coreos/airlock/internal/maintenance"
+)
+
+const (
+	// DefaultPriorityClass is the name of the class for nodes without a configured priority.
+	DefaultPriorityClass = "default"
 )
 
 // Settings stores runtime application configuration
@@ -11,17 +23,133 @@ type Settings struct {
 	ServicePort    uint64
 	ServiceTLS     bool
 
+	ServiceCertPath     string
+	ServiceKeyPath      string
+	ServiceClientCAPath string
+
+	ServiceClientIDCheck    bool
+	ServiceClientGroupCheck bool
+
+	ShutdownTimeout time.Duration
+
 	StatusAddress string
 	StatusEnabled bool
 	StatusPort    uint64
 	StatusTLS     bool
 
+	StatusCertPath     string
+	StatusKeyPath      string
+	StatusClientCAPath string
+
+	AdminAddress string
+	AdminEnabled bool
+	AdminPort    uint64
+	AdminTLS     bool
+
+	AdminCertPath     string
+	AdminKeyPath      string
+	AdminClientCAPath string
+	AdminTokenPath    string
+	AdminToken        Secret
+
 	EtcdEndpoints     []string
 	ClientCertPubPath string
 	ClientCertKeyPath string
 	EtcdTxnTimeout    time.Duration
 
-	LockGroups map[string]uint64
+	LockGroups     map[string]GroupSettings
+	ReconcileSlots bool
+	WaiterTimeout  time.Duration
+	MemberTimeout  time.Duration
+
+	PriorityClasses []PriorityClass
+	PriorityAging   time.Duration
+
+	NodeWeights []NodeWeight
+
+	GroupMemberships []GroupMembership
+}
+
+// PriorityClass stores a named priority for nodes waiting on a slot
+type PriorityClass struct {
+	// Name is the class name, used in metrics.
+	Name string
+	// Priority is the class priority, higher values are granted a slot first.
+	Priority int
+	// IDs holds shell patterns (as in `path.Match`) of node IDs in this class.
+	IDs []string
+}
+
+// GroupSettings stores runtime configuration for a single lock group
+type GroupSettings struct {
+	// Slots is the maximum number of concurrent lock holders.
+	Slots uint64
+	// SlotsPercent is the maximum percentage of known group members holding a
+	// lock at the same time. If not zero, it takes precedence over Slots.
+	SlotsPercent uint64
+	// MinSlots is the lower bound for percentage-based slots.
+	MinSlots uint64
+	// MaxSlots is the upper bound for percentage-based slots (zero means unbounded).
+	MaxSlots uint64
+	// MaxHold is the maximum lock duration for a holder (zero means unlimited).
+	MaxHold time.Duration
+	// Parent is the name of the parent group (optional). Locks in this group
+	// also consume a slot in the parent group, and in all its ancestors.
+	Parent string
+	// MaintenanceTimezone is the time zone of maintenance windows (empty means UTC).
+	MaintenanceTimezone string
+	// MaintenanceWindows holds the recurring windows in which locks can be
+	// granted (empty means always).
+	MaintenanceWindows []WindowSettings
+	// Maintenance is the schedule built from MaintenanceWindows.
+	Maintenance *maintenance.Schedule
+	// BlackoutPaths holds paths to iCalendar files, whose events block new locks.
+	BlackoutPaths []string
+	// Blackouts holds the calendars loaded from BlackoutPaths.
+	Blackouts []*maintenance.Calendar
+	// SecretPath is the path to a shared secret for client authentication (optional).
+	SecretPath string
+	// Secret is the shared secret loaded from SecretPath.
+	Secret Secret
+}
+
+// EffectiveSlots returns the number of slots for a group with `members` known
+// members. Percentage-based slots are rounded up, then clamped between the
+// configured minimum and maximum.
+func (g GroupSettings) EffectiveSlots(members uint64) uint64 {
+	if g.SlotsPercent == 0 {
+		return g.Slots
+	}
+
+	slots := (members*g.SlotsPercent + 99) / 100
+	if slots < g.MinSlots {
+		slots = g.MinSlots
+	}
+	if g.MaxSlots > 0 && slots > g.MaxSlots {
+		slots = g.MaxSlots
+	}
+	return slots
+}
+
+// WindowSettings stores a recurring maintenance window
+type WindowSettings struct {
+	// Days holds the names of weekdays on which the window starts (empty means every day).
+	Days []string
+	// Start is the window start time of day, in "HH:MM" format.
+	Start string
+	// End is the window end time of day, in "HH:MM" format.
+	End string
+}
+
+// Secret is a sensitive value, redacted when formatted.
+type Secret []byte
+
+// String returns a redacted representation of the secret.
+func (s Secret) String() string {
+	if len(s) == 0 {
+		return ""
+	}
+	return "<redacted>"
 }
 
 // Parse parses a TOML configuration file and default values
@@ -36,15 +164,256 @@ func Parse(fpath string) (Settings, error) {
 
 	// Make sure there is at least one reboot group
 	if len(settings.LockGroups) == 0 {
-		settings.LockGroups["default"] = 1
+		settings.LockGroups["default"] = GroupSettings{Slots: 1, MinSlots: 1}
+	}
+	if err := loadSecrets(&settings); err != nil {
+		return Settings{}, err
 	}
-	if settings.ServiceTLS {
-		return Settings{}, errors.New("TLS mode not yet implemented")
+	if err := loadAdminToken(&settings); err != nil {
+		return Settings{}, err
+	}
+	if err := loadSchedules(&settings); err != nil {
+		return Settings{}, err
+	}
+	if err := loadCalendars(&settings); err != nil {
+		return Settings{}, err
 	}
 
 	return settings, nil
 }
 
+// NodeWeight stores the number of slots consumed by matching nodes
+type NodeWeight struct {
+	// Weight is the number of slots consumed by each matching node.
+	Weight uint64
+	// IDs holds shell patterns (as in `path.Match`) of matching node IDs.
+	IDs []string
+}
+
+// WeightFor returns the number of slots consumed by node `id`, as configured
+// by the first matching weight entry (defaulting to one).
+func (s Settings) WeightFor(id string) uint64 {
+	for _, weight := range s.NodeWeights {
+		if MatchID(weight.IDs, id) {
+			return weight.Weight
+		}
+	}
+
+	return 1
+}
+
+// GroupMembership stores additional lock groups for matching nodes
+type GroupMembership struct {
+	// Groups holds the names of the additional groups.
+	Groups []string
+	// IDs holds shell patterns (as in `path.Match`) of matching node IDs.
+	IDs []string
+}
+
+// GroupsFor returns the names of all groups in which node `id` takes a slot
+// when locking `group`: the group chain of `group`, followed by the chains of
+// all additional groups from matching memberships, without duplicates.
+func (s Settings) GroupsFor(id string, group string) []string {
+	roots := []string{group}
+	for _, membership := range s.GroupMemberships {
+		if MatchID(membership.IDs, id) {
+			roots = append(roots, membership.Groups...)
+		}
+	}
+
+	groups := []string{}
+	seen := map[string]bool{}
+	for _, root := range roots {
+		for _, g := range s.GroupChain(root) {
+			if !seen[g] {
+				groups = append(groups, g)
+				seen[g] = true
+			}
+		}
+	}
+
+	return groups
+}
+
+// GroupChain returns the names of `group` and of all its ancestors, from the
+// group itself up to the root. It stops at the first unknown or repeated group.
+func (s Settings) GroupChain(group string) []string {
+	chain := []string{}
+	seen := map[string]bool{}
+	for group != "" && !seen[group] {
+		groupSettings, ok := s.LockGroups[group]
+		if !ok {
+			break
+		}
+		chain = append(chain, group)
+		seen[group] = true
+		group = groupSettings.Parent
+	}
+
+	return chain
+}
+
+// CheckSecrets returns an error if locking a group also takes a slot in a group
+// with a different shared secret, as a parent or through a membership.
+//
+// Clients are only authenticated for the group they request, so that parents
+// and memberships must not reach groups protected by another secret.
+func (s Settings) CheckSecrets() error {
+	groups := make([]string, 0, len(s.LockGroups))
+	for group := range s.LockGroups {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	for _, group := range groups {
+		secret := s.LockGroups[group].Secret
+		for _, parent := range s.GroupChain(group)[1:] {
+			if !bytes.Equal(s.LockGroups[parent].Secret, secret) {
+				return fmt.Errorf("group %q: parent group %q has a different secret", group, parent)
+			}
+		}
+	}
+	// Any group may be requested by nodes matching a membership.
+	for _, membership := range s.GroupMemberships {
+		for _, member := range membership.Groups {
+			secret := s.LockGroups[member].Secret
+			if len(secret) == 0 {
+				continue
+			}
+			for _, group := range groups {
+				if !bytes.Equal(s.LockGroups[group].Secret, secret) {
+					return fmt.Errorf("group membership: group %q has a different secret than group %q", member, group)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// PriorityClassFor returns the first priority class matching node `id`,
+// or a zero-priority "default" class.
+func (s Settings) PriorityClassFor(id string) PriorityClass {
+	for _, class := range s.PriorityClasses {
+		if MatchID(class.IDs, id) {
+			return class
+		}
+	}
+
+	return PriorityClass{Name: DefaultPriorityClass}
+}
+
+// MatchID returns whether node `id` matches any of the given shell patterns.
+func MatchID(patterns []string, id string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, id); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// loadSecrets reads all configured per-group shared secrets
+func loadSecrets(settings *Settings) error {
+	for group, groupSettings := range settings.LockGroups {
+		if groupSettings.SecretPath == "" {
+			continue
+		}
+		content, err := os.ReadFile(groupSettings.SecretPath)
+		if err != nil {
+			return fmt.Errorf("failed to read secret for group %q: %w", group, err)
+		}
+		secret := bytes.TrimSpace(content)
+		if len(secret) == 0 {
+			return fmt.Errorf("empty secret for group %q", group)
+		}
+		groupSettings.Secret = secret
+		settings.LockGroups[group] = groupSettings
+	}
+
+	return nil
+}
+
+// loadAdminToken reads the admin API token, if configured
+func loadAdminToken(settings *Settings) error {
+	if settings.AdminTokenPath == "" {
+		return nil
+	}
+	content, err := os.ReadFile(settings.AdminTokenPath)
+	if err != nil {
+		return fmt.Errorf("failed to read admin token: %w", err)
+	}
+	token := bytes.TrimSpace(content)
+	if len(token) == 0 {
+		return errors.New("empty admin token")
+	}
+	settings.AdminToken = token
+
+	return nil
+}
+
+// loadSchedules builds all configured per-group maintenance schedules
+func loadSchedules(settings *Settings) error {
+	for group, groupSettings := range settings.LockGroups {
+		if len(groupSettings.MaintenanceWindows) == 0 {
+			continue
+		}
+		windows := make([]maintenance.Window, 0, len(groupSettings.MaintenanceWindows))
+		for _, windowSettings := range groupSettings.MaintenanceWindows {
+			window, err := maintenance.ParseWindow(windowSettings.Days, windowSettings.Start, windowSettings.End)
+			if err != nil {
+				return fmt.Errorf("invalid maintenance window for group %q: %w", group, err)
+			}
+			windows = append(windows, window)
+		}
+		schedule, err := maintenance.NewSchedule(groupSettings.MaintenanceTimezone, windows)
+		if err != nil {
+			return fmt.Errorf("invalid maintenance time zone for group %q: %w", group, err)
+		}
+		groupSettings.Maintenance = schedule
+		settings.LockGroups[group] = groupSettings
+	}
+
+	return nil
+}
+
+// loadCalendars loads all configured per-group blackout calendars, sharing
+// calendars between groups
+func loadCalendars(settings *Settings) error {
+	calendars := map[string]*maintenance.Calendar{}
+	for group, groupSettings := range settings.LockGroups {
+		groupSettings.Blackouts = nil
+		for _, path := range groupSettings.BlackoutPaths {
+			calendar, ok := calendars[path]
+			if !ok {
+				var err error
+				calendar, err = maintenance.NewCalendar(path)
+				if err != nil {
+					return fmt.Errorf("failed to load blackout calendar for group %q: %w", group, err)
+				}
+				calendars[path] = calendar
+			}
+			groupSettings.Blackouts = append(groupSettings.Blackouts, calendar)
+		}
+		settings.LockGroups[group] = groupSettings
+	}
+
+	return nil
+}
+
+// ActiveBlackout returns the first blackout active at time `now` in any of the
+// group calendars, or nil.
+func (g GroupSettings) ActiveBlackout(now time.Time) *maintenance.Blackout {
+	for _, calendar := range g.Blackouts {
+		if blackout := calendar.Active(now); blackout != nil {
+			return blackout
+		}
+	}
+
+	return nil
+}
+
 // defaultSettings returns default settings for airlock commands
 func defaultSettings() Settings {
 	return Settings{
@@ -52,9 +421,17 @@ func defaultSettings() Settings {
 		ServicePort:    9090,
 		ServiceTLS:     true,
 
+		ShutdownTimeout: time.Duration(10) * time.Second,
+
+		AdminAddress: "127.0.0.1",
+		AdminPort:    9092,
+		AdminTLS:     true,
+
 		EtcdEndpoints:  []string{},
 		EtcdTxnTimeout: time.Duration(3) * time.Second,
 
-		LockGroups: make(map[string]uint64),
+		LockGroups:    make(map[string]GroupSettings),
+		WaiterTimeout: time.Duration(15) * time.Minute,
+		PriorityAging: time.Duration(1) * time.Hour,
 	}
 }
@@ -0,0 +1,145 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEffectiveSlots(t *testing.T) {
+	tests := []struct {
+		group    GroupSettings
+		members  uint64
+		expected uint64
+	}{
+		{GroupSettings{Slots: 3}, 100, 3},
+		{GroupSettings{Slots: 3, SlotsPercent: 10, MinSlots: 1}, 0, 1},
+		{GroupSettings{Slots: 3, SlotsPercent: 10, MinSlots: 1}, 5, 1},
+		{GroupSettings{Slots: 3, SlotsPercent: 10, MinSlots: 1}, 11, 2},
+		{GroupSettings{Slots: 3, SlotsPercent: 10, MinSlots: 1}, 100, 10},
+		{GroupSettings{SlotsPercent: 10, MinSlots: 2, MaxSlots: 5}, 10, 2},
+		{GroupSettings{SlotsPercent: 10, MinSlots: 2, MaxSlots: 5}, 1000, 5},
+		{GroupSettings{SlotsPercent: 100}, 7, 7},
+	}
+
+	for i, tt := range tests {
+		slots := tt.group.EffectiveSlots(tt.members)
+		if slots != tt.expected {
+			t.Errorf("#%d: expected %d slots, got %d", i, tt.expected, slots)
+		}
+	}
+}
+
+func TestGroupChain(t *testing.T) {
+	settings := Settings{
+		LockGroups: map[string]GroupSettings{
+			"dc":     {},
+			"rack-a": {Parent: "dc"},
+			"rack-b": {Parent: "dc"},
+			"node":   {Parent: "rack-a"},
+			"loop-a": {Parent: "loop-b"},
+			"loop-b": {Parent: "loop-a"},
+		},
+	}
+
+	tests := []struct {
+		group    string
+		expected []string
+	}{
+		{"dc", []string{"dc"}},
+		{"rack-b", []string{"rack-b", "dc"}},
+		{"node", []string{"node", "rack-a", "dc"}},
+		{"loop-a", []string{"loop-a", "loop-b"}},
+		{"unknown", []string{}},
+	}
+
+	for _, tt := range tests {
+		chain := settings.GroupChain(tt.group)
+		if !reflect.DeepEqual(chain, tt.expected) {
+			t.Errorf("group %q: expected %v, got %v", tt.group, tt.expected, chain)
+		}
+	}
+}
+
+func TestGroupsFor(t *testing.T) {
+	settings := Settings{
+		LockGroups: map[string]GroupSettings{
+			"dc":      {},
+			"storage": {Parent: "dc"},
+			"ingress": {Parent: "dc"},
+			"edge":    {},
+		},
+		GroupMemberships: []GroupMembership{
+			{Groups: []string{"ingress"}, IDs: []string{"store-ingress-*"}},
+			{Groups: []string{"edge", "storage"}, IDs: []string{"*-edge"}},
+		},
+	}
+
+	tests := []struct {
+		id       string
+		group    string
+		expected []string
+	}{
+		{"store-1", "storage", []string{"storage", "dc"}},
+		{"store-ingress-1", "storage", []string{"storage", "dc", "ingress"}},
+		{"store-ingress-edge", "storage", []string{"storage", "dc", "ingress", "edge"}},
+		{"lb-edge", "ingress", []string{"ingress", "dc", "edge", "storage"}},
+	}
+
+	for _, tt := range tests {
+		groups := settings.GroupsFor(tt.id, tt.group)
+		if !reflect.DeepEqual(groups, tt.expected) {
+			t.Errorf("node %q: expected %v, got %v", tt.id, tt.expected, groups)
+		}
+	}
+}
+
+func TestCheckSecrets(t *testing.T) {
+	secret := []byte("s3cr3t")
+	tests := []struct {
+		groups      map[string]GroupSettings
+		memberships []GroupMembership
+		valid       bool
+	}{
+		{
+			map[string]GroupSettings{"dc": {Secret: secret}, "racks": {Parent: "dc", Secret: secret}, "edge": {}},
+			nil,
+			true,
+		},
+		{
+			map[string]GroupSettings{"dc": {Secret: secret}, "racks": {Parent: "dc"}},
+			nil,
+			false,
+		},
+		{
+			map[string]GroupSettings{"dc": {}, "racks": {Parent: "dc", Secret: secret}},
+			nil,
+			false,
+		},
+		{
+			map[string]GroupSettings{"dc": {}, "edge": {}},
+			[]GroupMembership{{Groups: []string{"edge"}, IDs: []string{"*"}}},
+			true,
+		},
+		{
+			map[string]GroupSettings{"dc": {}, "edge": {Secret: secret}},
+			[]GroupMembership{{Groups: []string{"edge"}, IDs: []string{"*"}}},
+			false,
+		},
+		{
+			map[string]GroupSettings{"dc": {Secret: secret}, "edge": {Secret: secret}},
+			[]GroupMembership{{Groups: []string{"edge"}, IDs: []string{"*"}}},
+			true,
+		},
+	}
+
+	for i, tt := range tests {
+		settings := Settings{LockGroups: tt.groups, GroupMemberships: tt.memberships}
+		err := settings.CheckSecrets()
+		if tt.valid && err != nil {
+			t.Errorf("case %d: unexpected error: %v", i, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("case %d: expected error", i)
+		}
+	}
+}
@@ -10,23 +10,45 @@ import (
 type tomlConfig struct {
 	Service *serviceSection `toml:"service"`
 	Status  *statusSection  `toml:"status"`
+	Admin   *adminSection   `toml:"admin"`
 	Etcd3   *etcd3Section   `toml:"etcd3"`
 	Lock    *lockSection    `toml:"lock"`
 }
 
 // serviceSection holds the optional `service` fragment
 type serviceSection struct {
-	Address *string `toml:"address"`
-	Port    *uint64 `toml:"port"`
-	TLS     *bool   `toml:"tls"`
+	Address           *string `toml:"address"`
+	Port              *uint64 `toml:"port"`
+	TLS               *bool   `toml:"tls"`
+	TLSCertPath       string  `toml:"tls_cert_path"`
+	TLSKeyPath        string  `toml:"tls_key_path"`
+	TLSClientCAPath   string  `toml:"tls_client_ca_path"`
+	ClientIDCheck     *bool   `toml:"tls_client_id_check"`
+	ClientGroupCheck  *bool   `toml:"tls_client_group_check"`
+	ShutdownTimeoutMs *uint64 `toml:"shutdown_timeout_ms"`
 }
 
 // statusSection holds the optional `service` fragment
 type statusSection struct {
-	Address *string `toml:"address"`
-	Enabled *bool   `toml:"enabled"`
-	Port    *uint64 `toml:"port"`
-	TLS     *bool   `toml:"tls"`
+	Address         *string `toml:"address"`
+	Enabled         *bool   `toml:"enabled"`
+	Port            *uint64 `toml:"port"`
+	TLS             *bool   `toml:"tls"`
+	TLSCertPath     string  `toml:"tls_cert_path"`
+	TLSKeyPath      string  `toml:"tls_key_path"`
+	TLSClientCAPath string  `toml:"tls_client_ca_path"`
+}
+
+// adminSection holds the optional `admin` fragment
+type adminSection struct {
+	Address         *string `toml:"address"`
+	Enabled         *bool   `toml:"enabled"`
+	Port            *uint64 `toml:"port"`
+	TLS             *bool   `toml:"tls"`
+	TLSCertPath     string  `toml:"tls_cert_path"`
+	TLSKeyPath      string  `toml:"tls_key_path"`
+	TLSClientCAPath string  `toml:"tls_client_ca_path"`
+	TokenPath       string  `toml:"token_path"`
 }
 
 // etcd3Section holds the optional `etcd3` fragment
@@ -39,15 +61,64 @@ type etcd3Section struct {
 
 // lockSection holds the optional `lock` fragment
 type lockSection struct {
-	DefaultGroupName *string            `toml:"default_group_name"`
-	DefaultSlots     *uint64            `toml:"default_slots"`
-	Groups           []lockGroupSection `toml:"groups"`
+	DefaultGroupName    *string             `toml:"default_group_name"`
+	DefaultSlots        *uint64             `toml:"default_slots"`
+	DefaultMaxHoldSecs  *uint64             `toml:"default_max_hold_secs"`
+	DefaultSecretPath   *string             `toml:"default_secret_path"`
+	DefaultSlotsPercent *uint64             `toml:"default_slots_percent"`
+	DefaultMinSlots     *uint64             `toml:"default_min_slots"`
+	DefaultMaxSlots     *uint64             `toml:"default_max_slots"`
+	ReconcileSlots      *bool               `toml:"reconcile_slots"`
+	WaiterTimeoutSecs   *uint64             `toml:"waiter_timeout_secs"`
+	PriorityAgingSecs   *uint64             `toml:"priority_aging_secs"`
+	MemberTimeoutSecs   *uint64             `toml:"member_timeout_secs"`
+	BlackoutCalendars   []string            `toml:"blackout_calendars"`
+	Groups              []lockGroupSection  `toml:"groups"`
+	PriorityClasses     []prioritySection   `toml:"priority_classes"`
+	Weights             []weightSection     `toml:"weights"`
+	Memberships         []membershipSection `toml:"memberships"`
 }
 
 // lockGroupSection is a `lock.groups` entry
 type lockGroupSection struct {
-	Name  string  `toml:"name"`
-	Slots *uint64 `toml:"slots"`
+	Name         string  `toml:"name"`
+	Slots        *uint64 `toml:"slots"`
+	SlotsPercent *uint64 `toml:"slots_percent"`
+	MinSlots     *uint64 `toml:"min_slots"`
+	MaxSlots     *uint64 `toml:"max_slots"`
+	MaxHoldSecs  *uint64 `toml:"max_hold_secs"`
+	SecretPath   *string `toml:"secret_path"`
+	Parent       *string `toml:"parent"`
+
+	MaintenanceTimezone *string         `toml:"maintenance_timezone"`
+	MaintenanceWindows  []windowSection `toml:"maintenance_windows"`
+	BlackoutCalendars   []string        `toml:"blackout_calendars"`
+}
+
+// windowSection is a `lock.groups.maintenance_windows` entry
+type windowSection struct {
+	Days  []string `toml:"days"`
+	Start string   `toml:"start"`
+	End   string   `toml:"end"`
+}
+
+// prioritySection is a `lock.priority_classes` entry
+type prioritySection struct {
+	Name     string   `toml:"name"`
+	Priority int      `toml:"priority"`
+	IDs      []string `toml:"ids"`
+}
+
+// weightSection is a `lock.weights` entry
+type weightSection struct {
+	Weight uint64   `toml:"weight"`
+	IDs    []string `toml:"ids"`
+}
+
+// membershipSection is a `lock.memberships` entry
+type membershipSection struct {
+	Groups []string `toml:"groups"`
+	IDs    []string `toml:"ids"`
 }
 
 // parseConfig tries to parse and merge TOML config and default settings
@@ -74,6 +145,9 @@ func mergeToml(settings *Settings, cfg tomlConfig) {
 	if cfg.Status != nil {
 		mergeStatus(settings, *cfg.Status)
 	}
+	if cfg.Admin != nil {
+		mergeAdmin(settings, *cfg.Admin)
+	}
 	if cfg.Etcd3 != nil {
 		mergeEtcd(settings, *cfg.Etcd3)
 	}
@@ -96,6 +170,24 @@ func mergeService(settings *Settings, cfg serviceSection) {
 	if cfg.TLS != nil {
 		settings.ServiceTLS = *cfg.TLS
 	}
+	if len(cfg.TLSCertPath) > 0 {
+		settings.ServiceCertPath = cfg.TLSCertPath
+	}
+	if len(cfg.TLSKeyPath) > 0 {
+		settings.ServiceKeyPath = cfg.TLSKeyPath
+	}
+	if len(cfg.TLSClientCAPath) > 0 {
+		settings.ServiceClientCAPath = cfg.TLSClientCAPath
+	}
+	if cfg.ClientIDCheck != nil {
+		settings.ServiceClientIDCheck = *cfg.ClientIDCheck
+	}
+	if cfg.ClientGroupCheck != nil {
+		settings.ServiceClientGroupCheck = *cfg.ClientGroupCheck
+	}
+	if cfg.ShutdownTimeoutMs != nil {
+		settings.ShutdownTimeout = time.Duration(*cfg.ShutdownTimeoutMs) * time.Millisecond
+	}
 }
 
 func mergeStatus(settings *Settings, cfg statusSection) {
@@ -115,6 +207,46 @@ func mergeStatus(settings *Settings, cfg statusSection) {
 	if cfg.TLS != nil {
 		settings.StatusTLS = *cfg.TLS
 	}
+	if len(cfg.TLSCertPath) > 0 {
+		settings.StatusCertPath = cfg.TLSCertPath
+	}
+	if len(cfg.TLSKeyPath) > 0 {
+		settings.StatusKeyPath = cfg.TLSKeyPath
+	}
+	if len(cfg.TLSClientCAPath) > 0 {
+		settings.StatusClientCAPath = cfg.TLSClientCAPath
+	}
+}
+
+func mergeAdmin(settings *Settings, cfg adminSection) {
+	if settings == nil {
+		return
+	}
+
+	if cfg.Address != nil {
+		settings.AdminAddress = *cfg.Address
+	}
+	if cfg.Enabled != nil {
+		settings.AdminEnabled = *cfg.Enabled
+	}
+	if cfg.Port != nil {
+		settings.AdminPort = *cfg.Port
+	}
+	if cfg.TLS != nil {
+		settings.AdminTLS = *cfg.TLS
+	}
+	if len(cfg.TLSCertPath) > 0 {
+		settings.AdminCertPath = cfg.TLSCertPath
+	}
+	if len(cfg.TLSKeyPath) > 0 {
+		settings.AdminKeyPath = cfg.TLSKeyPath
+	}
+	if len(cfg.TLSClientCAPath) > 0 {
+		settings.AdminClientCAPath = cfg.TLSClientCAPath
+	}
+	if len(cfg.TokenPath) > 0 {
+		settings.AdminTokenPath = cfg.TokenPath
+	}
 }
 
 func mergeEtcd(settings *Settings, cfg etcd3Section) {
@@ -142,22 +274,101 @@ func mergeLock(settings *Settings, cfg lockSection) {
 	}
 
 	baseName := "default"
-	baseSlots := uint64(1)
+	base := GroupSettings{Slots: 1, MinSlots: 1}
 
 	if cfg.DefaultGroupName != nil {
 		baseName = *cfg.DefaultGroupName
 	}
 	if cfg.DefaultSlots != nil {
-		baseSlots = *cfg.DefaultSlots
+		base.Slots = *cfg.DefaultSlots
+	}
+	if cfg.DefaultMaxHoldSecs != nil {
+		base.MaxHold = time.Duration(*cfg.DefaultMaxHoldSecs) * time.Second
+	}
+	if cfg.DefaultSecretPath != nil {
+		base.SecretPath = *cfg.DefaultSecretPath
+	}
+	if cfg.DefaultSlotsPercent != nil {
+		base.SlotsPercent = *cfg.DefaultSlotsPercent
+	}
+	if cfg.DefaultMinSlots != nil {
+		base.MinSlots = *cfg.DefaultMinSlots
+	}
+	if cfg.DefaultMaxSlots != nil {
+		base.MaxSlots = *cfg.DefaultMaxSlots
+	}
+	base.BlackoutPaths = cfg.BlackoutCalendars
+	if cfg.ReconcileSlots != nil {
+		settings.ReconcileSlots = *cfg.ReconcileSlots
+	}
+	if cfg.WaiterTimeoutSecs != nil {
+		settings.WaiterTimeout = time.Duration(*cfg.WaiterTimeoutSecs) * time.Second
+	}
+	if cfg.PriorityAgingSecs != nil {
+		settings.PriorityAging = time.Duration(*cfg.PriorityAgingSecs) * time.Second
+	}
+	if cfg.MemberTimeoutSecs != nil {
+		settings.MemberTimeout = time.Duration(*cfg.MemberTimeoutSecs) * time.Second
+	}
+	for _, weight := range cfg.Weights {
+		settings.NodeWeights = append(settings.NodeWeights, NodeWeight{
+			Weight: weight.Weight,
+			IDs:    weight.IDs,
+		})
+	}
+	for _, membership := range cfg.Memberships {
+		settings.GroupMemberships = append(settings.GroupMemberships, GroupMembership{
+			Groups: membership.Groups,
+			IDs:    membership.IDs,
+		})
+	}
+	for _, class := range cfg.PriorityClasses {
+		settings.PriorityClasses = append(settings.PriorityClasses, PriorityClass{
+			Name:     class.Name,
+			Priority: class.Priority,
+			IDs:      class.IDs,
+		})
 	}
 
 	for _, group := range cfg.Groups {
-		slots := baseSlots
+		groupSettings := base
 		if group.Slots != nil {
-			slots = *group.Slots
+			// A fixed number of slots overrides an inherited percentage.
+			groupSettings.Slots = *group.Slots
+			groupSettings.SlotsPercent = 0
+		}
+		if group.SlotsPercent != nil {
+			groupSettings.SlotsPercent = *group.SlotsPercent
+		}
+		if group.MinSlots != nil {
+			groupSettings.MinSlots = *group.MinSlots
+		}
+		if group.MaxSlots != nil {
+			groupSettings.MaxSlots = *group.MaxSlots
+		}
+		if group.MaxHoldSecs != nil {
+			groupSettings.MaxHold = time.Duration(*group.MaxHoldSecs) * time.Second
+		}
+		if group.SecretPath != nil {
+			groupSettings.SecretPath = *group.SecretPath
+		}
+		if group.Parent != nil {
+			groupSettings.Parent = *group.Parent
+		}
+		if group.MaintenanceTimezone != nil {
+			groupSettings.MaintenanceTimezone = *group.MaintenanceTimezone
+		}
+		// Group calendars are in addition to lock-level ones.
+		groupSettings.BlackoutPaths = append(append([]string{}, base.BlackoutPaths...), group.BlackoutCalendars...)
+		for _, window := range group.MaintenanceWindows {
+			groupSettings.MaintenanceWindows = append(groupSettings.MaintenanceWindows, WindowSettings{
+				Days:  window.Days,
+				Start: window.Start,
+				End:   window.End,
+			})
 		}
-		settings.LockGroups[group.Name] = slots
+		settings.LockGroups[group.Name] = groupSettings
 	}
 
-	settings.LockGroups[baseName] = baseSlots
+	settings.LockGroups[baseName] = base
 }
@@ -0,0 +1,18 @@
+package lock
+
+import (
+	"errors"
+)
+
+var (
+	// ErrSlotsFull is returned when no semaphore slot is available.
+	ErrSlotsFull = errors.New("no semaphore slot available")
+	// ErrPaused is returned when new locks are refused because the group is paused.
+	ErrPaused = errors.New("group paused")
+	// ErrConflict is returned when the semaphore was concurrently modified.
+	ErrConflict = errors.New("conflict on semaphore detected")
+	// ErrUnavailable is returned when the etcd backend cannot be reached.
+	ErrUnavailable = errors.New("etcd backend unavailable")
+	// ErrCorrupt is returned when the semaphore stored in etcd is invalid.
+	ErrCorrupt = errors.New("corrupt semaphore state")
+)
@@ -5,30 +5,64 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math/rand"
 	"net/url"
+	"reflect"
+	"sync/atomic"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	transport "go.etcd.io/etcd/client/pkg/v3/transport"
 	clientv3 "go.etcd.io/etcd/client/v3"
 )
 
 const (
 	keyTemplate = "com.coreos.airlock/groups/%s/v1/semaphore"
+
+	// maxTxnAttempts is the maximum number of attempts for a conflicting transaction.
+	maxTxnAttempts = 5
+	// retryBaseDelay is the initial delay between conflicting transaction attempts.
+	retryBaseDelay = 50 * time.Millisecond
 )
 
 var (
 	// ErrNilManager is returned on nil manager
 	ErrNilManager = errors.New("nil Manager")
+
+	// conflictsCounter holds a metrics counter with per-group transaction conflicts.
+	conflictsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "airlock_semaphore_conflicts_total",
+		Help: "Total number of conflicting semaphore transactions, per group.",
+	}, []string{"group"})
+	// retriesCounter holds a metrics counter with per-group transaction retries.
+	retriesCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "airlock_semaphore_retries_total",
+		Help: "Total number of retried semaphore transactions, per group.",
+	}, []string{"group"})
 )
 
 // Manager takes care of locking for clients
 type Manager struct {
-	client  *clientv3.Client
-	keyPath string
+	client         *clientv3.Client
+	group          string
+	keyPath        string
+	membersPrefix  string
+	releasesPrefix string
+	slots          uint64
+	initialized    uint32
 }
 
-// NewManager returns a new lock manager, ensuring the underlying semaphore is initialized.
-func NewManager(ctx context.Context, etcdURLs []string, certPubPath string, certKeyPath string, txnTimeoutMs time.Duration, group string, slots uint64) (*Manager, error) {
+// Collectors returns all lock-related metrics collectors.
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		conflictsCounter,
+		retriesCounter,
+	}
+}
+
+// NewClient returns a new etcd client, meant to be shared by all managers
+// for the lifetime of the service.
+func NewClient(etcdURLs []string, certPubPath string, certKeyPath string, txnTimeout time.Duration) (*clientv3.Client, error) {
 	tlsInfo := transport.TLSInfo{
 		CertFile: certPubPath,
 		KeyFile:  certKeyPath,
@@ -41,66 +75,252 @@ func NewManager(ctx context.Context, etcdURLs []string, certPubPath string, cert
 
 	client, err := clientv3.New(clientv3.Config{
 		Endpoints:   etcdURLs,
-		DialTimeout: time.Duration(txnTimeoutMs) * time.Millisecond,
+		DialTimeout: txnTimeout,
 		TLS:         tlsConfig,
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	keyPath := fmt.Sprintf(keyTemplate, url.QueryEscape(group))
-	manager := Manager{client, keyPath}
+	return client, nil
+}
 
-	if err := manager.ensureInit(ctx, slots); err != nil {
-		return nil, err
+// NewManager returns a new lock manager for `group`, backed by a shared etcd client.
+//
+// The underlying semaphore is not touched until `EnsureInit` is called.
+func NewManager(client *clientv3.Client, group string, slots uint64) (*Manager, error) {
+	if client == nil {
+		return nil, errors.New("nil etcd client")
+	}
+
+	keyPath := fmt.Sprintf(keyTemplate, url.QueryEscape(group))
+	manager := Manager{
+		client:         client,
+		group:          group,
+		keyPath:        keyPath,
+		membersPrefix:  fmt.Sprintf(membersTemplate, url.QueryEscape(group)),
+		releasesPrefix: fmt.Sprintf(releasesTemplate, url.QueryEscape(group)),
+		slots:          slots,
 	}
 
 	return &manager, nil
 }
 
-// RecursiveLock adds this lock `id` as a holder of the semaphore
+// Slots returns the number of slots used when initializing the semaphore.
+func (m *Manager) Slots() uint64 {
+	if m == nil {
+		return 0
+	}
+
+	return m.slots
+}
+
+// EnsureInit initializes the semaphore in etcd, if it does not exist yet.
 //
-// It will return an error if there is a problem getting or setting the
-// semaphore, or if the maximum number of holders has been reached.
-func (m *Manager) RecursiveLock(ctx context.Context, id string) (*Semaphore, error) {
-	sem, version, err := m.get(ctx)
-	if err != nil {
-		return nil, err
+// Once it has succeeded, further calls are no-ops.
+func (m *Manager) EnsureInit(ctx context.Context) error {
+	if m == nil {
+		return ErrNilManager
+	}
+	if atomic.LoadUint32(&m.initialized) == 1 {
+		return nil
 	}
 
-	held, err := sem.RecursiveLock(id)
-	if err != nil {
-		return nil, err
+	if err := m.ensureInit(ctx, m.slots); err != nil {
+		return err
 	}
-	if held {
-		return sem, nil
+	atomic.StoreUint32(&m.initialized, 1)
+
+	return nil
+}
+
+// LockAll adds this lock `id` as a holder of the semaphores of all `managers`,
+// in a single transaction: either all semaphores are locked, or none is.
+//
+// If any semaphore is full, its wait queue (if enabled) is updated in etcd and
+// the others are left untouched. All managers must share the same etcd client.
+func LockAll(ctx context.Context, managers []*Manager, id string, opts LockOptions) ([]*Semaphore, error) {
+	groups := make([]string, len(managers))
+	for i, m := range managers {
+		if m != nil {
+			groups[i] = m.group
+		}
 	}
 
-	if err := m.set(ctx, sem, version); err != nil {
-		return nil, err
+	return updateAll(ctx, managers, func(sems []*Semaphore) ([]bool, error) {
+		return lockLevels(sems, groups, id, opts)
+	})
+}
+
+// lockLevels adds holder `id` to all semaphores in `sems` (of `groups`), or to
+// none, returning which semaphores changed.
+//
+// On failure semaphores are left unchanged, except for the wait queue of the
+// full (or paused) semaphore that refused the lock, if that queue changed.
+func lockLevels(sems []*Semaphore, groups []string, id string, opts LockOptions) ([]bool, error) {
+	changed := make([]bool, len(sems))
+	attempts := make([]*Semaphore, len(sems))
+	for i, sem := range sems {
+		attempt := sem.clone()
+		held, err := attempt.RecursiveLockWithOptions(id, opts)
+		if err != nil && i > 0 {
+			err = fmt.Errorf("group %q: %w", groups[i], err)
+		}
+		if err != nil {
+			// Persist the queue position at this level only, then report the error.
+			refused := make([]bool, len(sems))
+			var waitErr *WaitError
+			if errors.As(err, &waitErr) || errors.Is(err, ErrPaused) {
+				refused[i] = !reflect.DeepEqual(attempt.Waiters, sem.Waiters)
+				sems[i] = attempt
+			}
+			return refused, err
+		}
+		attempts[i] = attempt
+		changed[i] = !held
 	}
 
-	return sem, nil
+	copy(sems, attempts)
+	return changed, nil
 }
 
-// UnlockIfHeld removes this lock `id` as a holder of the semaphore
+// WaitAll records this lock `id` in the wait queues of the semaphores of all
+// `managers` (if enabled in `opts`), in a single transaction, without granting
+// any slot or pruning other waiters.
 //
-// It returns an error if there is a problem getting or setting the semaphore.
-func (m *Manager) UnlockIfHeld(ctx context.Context, id string) (*Semaphore, error) {
-	sem, version, err := m.get(ctx)
+// It is used when a lock is refused before any slot is considered (e.g. outside
+// of a maintenance window), so that waiting nodes keep their queue position.
+func WaitAll(ctx context.Context, managers []*Manager, id string, opts LockOptions) ([]*Semaphore, error) {
+	now := opts.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	return updateAll(ctx, managers, func(sems []*Semaphore) ([]bool, error) {
+		changed := make([]bool, len(sems))
+		if opts.WaiterTimeout <= 0 {
+			return changed, nil
+		}
+		for i, sem := range sems {
+			changed[i] = sem.enqueueWaiter(id, now, opts)
+		}
+		return changed, nil
+	})
+}
+
+// UnlockAll removes this lock `id` as a holder of the semaphores of all `managers`,
+// in a single transaction. It returns the updated semaphores and the lease
+// released from the first semaphore (nil if `id` held no lease there).
+func UnlockAll(ctx context.Context, managers []*Manager, id string) ([]*Semaphore, *Lease, error) {
+	var released *Lease
+	sems, err := updateAll(ctx, managers, func(sems []*Semaphore) ([]bool, error) {
+		var changed []bool
+		var err error
+		changed, released, err = unlockLevels(sems, id)
+		return changed, err
+	})
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	if err := sem.UnlockIfHeld(id); err != nil {
-		return nil, err
+	return sems, released, nil
+}
+
+// unlockLevels removes holder `id` from all semaphores in `sems` (and from their
+// wait queues), returning which semaphores changed and the lease released from
+// the first semaphore (nil if `id` held no lease there).
+func unlockLevels(sems []*Semaphore, id string) ([]bool, *Lease, error) {
+	var released *Lease
+	changed := make([]bool, len(sems))
+	for i, sem := range sems {
+		state := sem.NodeState(id)
+		if lease, ok := sem.Leases[id]; ok && i == 0 && state == NodeLocked {
+			released = &lease
+		}
+		if err := sem.UnlockIfHeld(id); err != nil {
+			return changed, nil, err
+		}
+		changed[i] = state != NodeIdle
 	}
 
-	if err := m.set(ctx, sem, version); err != nil {
-		return nil, err
+	return changed, released, nil
+}
+
+// ExpireHolders removes all holders whose lease is overdue, returning the
+// updated semaphore and the expired leases by holder ID.
+//
+// Holders without lease details (e.g. written by older versions) are assigned
+// a lease starting `now` and lasting `maxHold`, so that they eventually expire too.
+// An expired pause is cleared as well.
+func (m *Manager) ExpireHolders(ctx context.Context, now time.Time, maxHold time.Duration) (*Semaphore, map[string]Lease, error) {
+	var expired map[string]Lease
+	sem, err := m.update(ctx, func(sem *Semaphore) (bool, error) {
+		leases := make(map[string]Lease, len(sem.Leases))
+		for id, lease := range sem.Leases {
+			leases[id] = lease
+		}
+		ids, err := sem.ExpireHolders(now)
+		if err != nil {
+			return false, err
+		}
+		expired = make(map[string]Lease, len(ids))
+		for _, id := range ids {
+			expired[id] = leases[id]
+		}
+		adopted := sem.adoptLegacyHolders(now, maxHold)
+		unpaused := sem.Pause != nil && sem.Pause.Expired(now)
+		if unpaused {
+			sem.Pause = nil
+		}
+		return len(expired) != 0 || adopted || unpaused, nil
+	})
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return sem, nil
+	return sem, expired, nil
+}
+
+// ReconcileSlots updates the semaphore capacity to `slots`, returning the updated
+// semaphore and the previous number of slots. Existing holders are never dropped.
+func (m *Manager) ReconcileSlots(ctx context.Context, slots uint64) (*Semaphore, uint64, error) {
+	var previous uint64
+	sem, err := m.update(ctx, func(sem *Semaphore) (bool, error) {
+		previous = sem.TotalSlots
+		return sem.SetTotalSlots(slots)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return sem, previous, nil
+}
+
+// Pause pauses the semaphore from `now` until `until` (zero means no expiry),
+// so that no new holder is admitted. Existing holders can still unlock.
+func (m *Manager) Pause(ctx context.Context, now time.Time, until time.Time, reason string) (*Semaphore, error) {
+	return m.update(ctx, func(sem *Semaphore) (bool, error) {
+		if err := sem.SetPause(now, until, reason); err != nil {
+			return false, err
+		}
+		return true, nil
+	})
+}
+
+// Resume removes any pause from the semaphore, returning the updated
+// semaphore and whether it was paused.
+func (m *Manager) Resume(ctx context.Context) (*Semaphore, bool, error) {
+	var paused bool
+	sem, err := m.update(ctx, func(sem *Semaphore) (bool, error) {
+		var err error
+		paused, err = sem.Resume()
+		return paused, err
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	return sem, paused, nil
 }
 
 // FetchSemaphore fetches current semaphore version
@@ -113,15 +333,6 @@ func (m *Manager) FetchSemaphore(ctx context.Context) (*Semaphore, error) {
 	return semaphore, nil
 }
 
-// Close reaps all running goroutines
-func (m *Manager) Close() {
-	if m == nil {
-		return
-	}
-
-	m.client.Close()
-}
-
 // ensureInit initialize the semaphore in etcd, if it does not exist yet
 func (m *Manager) ensureInit(ctx context.Context, slots uint64) error {
 	if m == nil {
@@ -142,7 +353,7 @@ func (m *Manager) ensureInit(ctx context.Context, slots uint64) error {
 	).Commit()
 
 	if err != nil {
-		return err
+		return fmt.Errorf("%w: %s", ErrUnavailable, err)
 	}
 	return nil
 }
@@ -151,10 +362,10 @@ func (m *Manager) ensureInit(ctx context.Context, slots uint64) error {
 func (m *Manager) get(ctx context.Context) (*Semaphore, int64, error) {
 	resp, err := m.client.Get(ctx, m.keyPath)
 	if err != nil {
-		return nil, 0, err
+		return nil, 0, fmt.Errorf("%w: %s", ErrUnavailable, err)
 	}
 	if resp.Count != 1 {
-		return nil, 0, fmt.Errorf("unexpected number of results: %d", resp.Count)
+		return nil, 0, fmt.Errorf("%w: unexpected number of results: %d", ErrCorrupt, resp.Count)
 	}
 
 	var data []byte
@@ -165,48 +376,171 @@ func (m *Manager) get(ctx context.Context) (*Semaphore, int64, error) {
 		break
 	}
 	if version == 0 {
-		return nil, 0, errors.New("key at version 0")
+		return nil, 0, fmt.Errorf("%w: key at version 0", ErrCorrupt)
 	}
+	sem, err := decodeSemaphore(data)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return sem, version, nil
+}
+
+// decodeSemaphore decodes a semaphore value stored in etcd.
+func decodeSemaphore(data []byte) (*Semaphore, error) {
 	if len(data) == 0 {
-		return nil, 0, errors.New("empty semaphore value")
+		return nil, fmt.Errorf("%w: empty semaphore value", ErrCorrupt)
 	}
 
 	sem := &Semaphore{}
-	err = json.Unmarshal(data, sem)
+	if err := json.Unmarshal(data, sem); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrCorrupt, err)
+	}
+	return sem, nil
+}
+
+// update performs a read-modify-write cycle on the semaphore.
+//
+// `mutate` is applied to the current semaphore and returns whether it has been
+// modified and needs to be written back. It may return an error together with
+// changes to persist, in which case the error is returned after writing.
+func (m *Manager) update(ctx context.Context, mutate func(*Semaphore) (bool, error)) (*Semaphore, error) {
+	sems, err := updateAll(ctx, []*Manager{m}, func(sems []*Semaphore) ([]bool, error) {
+		changed, err := mutate(sems[0])
+		return []bool{changed}, err
+	})
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
 
-	return sem, version, nil
+	return sems[0], nil
 }
 
-// set updates the semaphore in etcd, if `version` matches the one previously observed
-func (m *Manager) set(ctx context.Context, sem *Semaphore, version int64) error {
-	if m == nil {
-		return ErrNilManager
+// updateAll performs a read-modify-write cycle on the semaphores of all
+// `managers`, writing them back in a single transaction.
+//
+// `mutate` is applied to the current semaphores (and may replace them in
+// place) and returns which of them have been modified and need to be written
+// back. It may return an error together with changes to persist, in which case
+// the error is returned after writing.
+// On version conflicts, the whole cycle is retried with a jittered backoff,
+// as long as the context deadline allows.
+func updateAll(ctx context.Context, managers []*Manager, mutate func([]*Semaphore) ([]bool, error)) ([]*Semaphore, error) {
+	if len(managers) == 0 {
+		return nil, ErrNilManager
+	}
+	keys := make(map[string]bool, len(managers))
+	for _, m := range managers {
+		if m == nil {
+			return nil, ErrNilManager
+		}
+		if keys[m.keyPath] {
+			return nil, fmt.Errorf("duplicate group %q in transaction", m.group)
+		}
+		keys[m.keyPath] = true
 	}
-	if sem == nil {
-		return ErrNilSemaphore
+	group := managers[0].group
+
+	for attempt := 0; ; attempt++ {
+		sems := make([]*Semaphore, len(managers))
+		versions := make([]int64, len(managers))
+		for i, m := range managers {
+			sem, version, err := m.get(ctx)
+			if err != nil {
+				return nil, err
+			}
+			sems[i] = sem
+			versions[i] = version
+		}
+
+		changed, mutateErr := mutate(sems)
+		anyChanged := false
+		for _, c := range changed {
+			anyChanged = anyChanged || c
+		}
+		if !anyChanged {
+			if mutateErr != nil {
+				return nil, mutateErr
+			}
+			return sems, nil
+		}
+
+		err := setAll(ctx, managers, sems, versions, changed)
+		if err == nil && mutateErr != nil {
+			return nil, mutateErr
+		}
+		if err == nil {
+			return sems, nil
+		}
+		if !errors.Is(err, ErrConflict) {
+			return nil, err
+		}
+
+		conflictsCounter.WithLabelValues(group).Inc()
+		if attempt+1 >= maxTxnAttempts {
+			return nil, err
+		}
+		if err := retryBackoff(ctx, attempt); err != nil {
+			return nil, ErrConflict
+		}
+		retriesCounter.WithLabelValues(group).Inc()
 	}
+}
 
-	data, err := json.Marshal(sem)
-	if err != nil {
-		return err
+// retryBackoff waits for a jittered exponential delay before retry `attempt`.
+//
+// It returns an error if the context would expire before the delay elapses.
+func retryBackoff(ctx context.Context, attempt int) error {
+	delay := retryDelay(attempt)
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+		return context.DeadlineExceeded
 	}
 
-	// Conditionally Put if version in etcd is still the same we observed.
-	// If the condition is not met, the transaction will return as "not succeeding".
-	resp, err := m.client.Txn(ctx).If(
-		clientv3.Compare(clientv3.Version(m.keyPath), "=", version),
-	).Then(
-		clientv3.OpPut(m.keyPath, string(data)),
-	).Commit()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// retryDelay returns a random delay before retry `attempt` (starting at zero),
+// between half and all of `retryBaseDelay` doubled on each attempt.
+func retryDelay(attempt int) time.Duration {
+	ceiling := retryBaseDelay << uint(attempt)
+	return ceiling/2 + time.Duration(rand.Int63n(int64(ceiling/2)+1))
+}
 
+// setAll updates the changed semaphores in etcd in a single transaction, if
+// the versions of all semaphores match the ones previously observed
+func setAll(ctx context.Context, managers []*Manager, sems []*Semaphore, versions []int64, changed []bool) error {
+	cmps := make([]clientv3.Cmp, 0, len(managers))
+	ops := make([]clientv3.Op, 0, len(managers))
+	for i, m := range managers {
+		if sems[i] == nil {
+			return ErrNilSemaphore
+		}
+		cmps = append(cmps, clientv3.Compare(clientv3.Version(m.keyPath), "=", versions[i]))
+		if !changed[i] {
+			continue
+		}
+		data, err := json.Marshal(sems[i])
+		if err != nil {
+			return err
+		}
+		ops = append(ops, clientv3.OpPut(m.keyPath, string(data)))
+	}
+
+	// Conditionally Put if versions in etcd are still the same we observed.
+	// If any condition is not met, the transaction will return as "not succeeding".
+	resp, err := managers[0].client.Txn(ctx).If(cmps...).Then(ops...).Commit()
 	if err != nil {
-		return err
+		return fmt.Errorf("%w: %s", ErrUnavailable, err)
 	}
 	if !resp.Succeeded {
-		return errors.New("conflict on semaphore detected, aborting")
+		return ErrConflict
 	}
 
 	return nil
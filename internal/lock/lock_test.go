@@ -0,0 +1,206 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLockLevels(t *testing.T) {
+	groups := []string{"rack", "dc"}
+	now := time.Date(2021, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	// A full parent refuses the lock, leaving the child untouched.
+	rack, dc := NewSemaphore(2), NewSemaphore(1)
+	if _, err := dc.RecursiveLock("other"); err != nil {
+		t.Fatal(err)
+	}
+	sems := []*Semaphore{rack, dc}
+	changed, err := lockLevels(sems, groups, "a", LockOptions{Now: now})
+	if !errors.Is(err, ErrSlotsFull) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(changed, []bool{false, false}) {
+		t.Errorf("unexpected changes: %v", changed)
+	}
+	if len(sems[0].Holders) != 0 || sems[0] != rack {
+		t.Errorf("child semaphore modified: %+v", sems[0])
+	}
+
+	// With the wait queue enabled, only the refusing level is persisted.
+	opts := LockOptions{Now: now, WaiterTimeout: time.Hour}
+	changed, err = lockLevels(sems, groups, "a", opts)
+	var waitErr *WaitError
+	if !errors.As(err, &waitErr) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(changed, []bool{false, true}) {
+		t.Errorf("unexpected changes: %v", changed)
+	}
+	if len(sems[0].Holders) != 0 || sems[1].QueuePosition("a") != 1 {
+		t.Errorf("unexpected semaphores: %+v, %+v", sems[0], sems[1])
+	}
+
+	// Once the parent is free, all levels are locked.
+	if err := sems[1].UnlockIfHeld("other"); err != nil {
+		t.Fatal(err)
+	}
+	changed, err = lockLevels(sems, groups, "a", opts)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(changed, []bool{true, true}) {
+		t.Errorf("unexpected changes: %v", changed)
+	}
+	for i, sem := range sems {
+		if sem.NodeState("a") != NodeLocked {
+			t.Errorf("group %s: not locked: %+v", groups[i], sem)
+		}
+	}
+
+	// Relocking changes nothing.
+	changed, err = lockLevels(sems, groups, "a", opts)
+	if err != nil || !reflect.DeepEqual(changed, []bool{false, false}) {
+		t.Errorf("unexpected relock result: %v, %v", changed, err)
+	}
+
+	// A paused level persists the queue position as well.
+	rack, dc = NewSemaphore(1), NewSemaphore(1)
+	if err := dc.SetPause(now, time.Time{}, ""); err != nil {
+		t.Fatal(err)
+	}
+	sems = []*Semaphore{rack, dc}
+	changed, err = lockLevels(sems, groups, "a", opts)
+	if !errors.Is(err, ErrPaused) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(changed, []bool{false, true}) || sems[1].QueuePosition("a") != 1 {
+		t.Errorf("unexpected changes: %v, %+v", changed, sems[1])
+	}
+}
+
+func TestLockLevelsWaiterRefresh(t *testing.T) {
+	groups := []string{"rack"}
+	now := time.Date(2021, time.March, 1, 12, 0, 0, 0, time.UTC)
+	sem := NewSemaphore(1)
+	if _, err := sem.RecursiveLock("other"); err != nil {
+		t.Fatal(err)
+	}
+	sems := []*Semaphore{sem}
+
+	tests := []struct {
+		offset   time.Duration
+		priority int
+		changed  bool
+	}{
+		// Enqueued.
+		{0, 0, true},
+		// Polls within the resolution are not persisted.
+		{10 * time.Second, 0, false},
+		{59 * time.Second, 0, false},
+		// Older contact times are refreshed.
+		{time.Minute, 0, true},
+		{90 * time.Second, 0, false},
+		// Priority changes are persisted at once.
+		{100 * time.Second, 5, true},
+	}
+	for _, tt := range tests {
+		opts := LockOptions{Now: now.Add(tt.offset), WaiterTimeout: 15 * time.Minute, Priority: tt.priority}
+		changed, err := lockLevels(sems, groups, "a", opts)
+		if !errors.Is(err, ErrSlotsFull) {
+			t.Errorf("offset %s: unexpected error: %v", tt.offset, err)
+		}
+		if changed[0] != tt.changed {
+			t.Errorf("offset %s: expected changed %t, got %t", tt.offset, tt.changed, changed[0])
+		}
+	}
+	if waiter := sems[0].Waiters[0]; !waiter.LastSeen.Equal(now.Add(100*time.Second)) || waiter.Priority != 5 {
+		t.Errorf("unexpected waiter: %+v", waiter)
+	}
+
+	// Short timeouts cap the resolution, so that polling waiters are kept.
+	opts := LockOptions{Now: now.Add(130 * time.Second), WaiterTimeout: time.Minute, Priority: 5}
+	if changed, _ := lockLevels(sems, groups, "a", opts); !changed[0] {
+		t.Error("expected refresh with short waiter timeout")
+	}
+}
+
+func TestUnlockLevels(t *testing.T) {
+	now := time.Date(2021, time.March, 1, 12, 0, 0, 0, time.UTC)
+	rack, dc, other := NewSemaphore(1), NewSemaphore(1), NewSemaphore(1)
+	sems := []*Semaphore{rack, dc, other}
+	if _, err := lockLevels(sems[:2], []string{"rack", "dc"}, "a", LockOptions{Now: now, MaxHold: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.RecursiveLock("b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.RecursiveLockWithOptions("a", LockOptions{Now: now, WaiterTimeout: time.Hour}); !errors.Is(err, ErrSlotsFull) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Held and queued levels change, and the first lease is released.
+	changed, released, err := unlockLevels(sems, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(changed, []bool{true, true, true}) {
+		t.Errorf("unexpected changes: %v", changed)
+	}
+	if released == nil || released.MaxHoldSecs != 3600 {
+		t.Errorf("unexpected released lease: %v", released)
+	}
+	for i, sem := range sems {
+		if sem.NodeState("a") != NodeIdle {
+			t.Errorf("level %d: not unlocked: %+v", i, sem)
+		}
+	}
+
+	// Unlocking again changes nothing.
+	changed, released, err = unlockLevels(sems, "a")
+	if err != nil || released != nil || !reflect.DeepEqual(changed, []bool{false, false, false}) {
+		t.Errorf("unexpected unlock result: %v, %v, %v", changed, released, err)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	for attempt := 0; attempt < maxTxnAttempts; attempt++ {
+		ceiling := retryBaseDelay << uint(attempt)
+		for i := 0; i < 1000; i++ {
+			if delay := retryDelay(attempt); delay < ceiling/2 || delay > ceiling {
+				t.Fatalf("attempt %d: delay %s out of [%s, %s]", attempt, delay, ceiling/2, ceiling)
+			}
+		}
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	// Backing off past the deadline fails at once, without sleeping.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := retryBackoff(ctx, maxTxnAttempts-1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("slept for %s before failing", elapsed)
+	}
+
+	// Cancelled contexts stop the backoff.
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if err := retryBackoff(ctx, 0); !errors.Is(err, context.Canceled) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// Otherwise the backoff sleeps for the jittered delay.
+	start = time.Now()
+	if err := retryBackoff(context.Background(), 0); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < retryBaseDelay/2 {
+		t.Errorf("slept for %s only", elapsed)
+	}
+}
@@ -0,0 +1,306 @@
+package lock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	membersTemplate = "com.coreos.airlock/groups/%s/v1/nodes/"
+
+	// memberSeenResolution is the resolution of registry contact times.
+	memberSeenResolution = time.Minute
+)
+
+// MemberEvent is the kind of contact a node made with its group.
+type MemberEvent int
+
+const (
+	// MemberSeen is any contact, without change of state.
+	MemberSeen MemberEvent = iota
+	// MemberGranted is a granted pre-reboot lock request.
+	MemberGranted
+	// MemberSteady is a steady-state report.
+	MemberSteady
+)
+
+// Member holds registry details for a node which contacted a group.
+type Member struct {
+	// FirstSeen is the time at which the node first contacted the group.
+	FirstSeen time.Time `json:"first_seen"`
+	// LastSeen is the time at which the node last contacted the group (with
+	// a resolution of one minute).
+	LastSeen time.Time `json:"last_seen"`
+	// LastGrant is the time at which the node was last granted a reboot slot.
+	LastGrant time.Time `json:"last_grant"`
+	// LastSteadyState is the time at which the node last reported a steady
+	// state (with a resolution of one minute).
+	LastSteadyState time.Time `json:"last_steady_state"`
+	// LastRebootSecs is the time in seconds between the last grant and the
+	// following steady-state report.
+	LastRebootSecs uint64 `json:"last_reboot_secs,omitempty"`
+	// Reboots is the number of completed reboots (grants followed by a steady state).
+	Reboots uint64 `json:"reboots,omitempty"`
+}
+
+// Observe updates the member details for a contact of kind `event` at time `now`,
+// returning whether the node state changed (as opposed to contact times only).
+//
+// Repeated grants during the same reboot keep the original grant time.
+func (m *Member) Observe(now time.Time, event MemberEvent) bool {
+	now = now.UTC()
+	changed := false
+	if m.FirstSeen.IsZero() {
+		m.FirstSeen = now
+		changed = true
+	}
+	m.LastSeen = now
+
+	switch event {
+	case MemberGranted:
+		if !m.Rebooting() {
+			m.LastGrant = now
+			changed = true
+		}
+	case MemberSteady:
+		if m.Rebooting() {
+			m.LastRebootSecs = uint64(now.Sub(m.LastGrant) / time.Second)
+			m.Reboots++
+			changed = true
+		}
+		m.LastSteadyState = now
+	}
+
+	return changed
+}
+
+// Rebooting returns whether the node was granted a slot and has not reported
+// a steady state since.
+func (m Member) Rebooting() bool {
+	return m.LastGrant.After(m.LastSteadyState)
+}
+
+// RebootedSince returns whether the node completed a reboot after time `t`.
+func (m Member) RebootedSince(t time.Time) bool {
+	return m.Reboots > 0 && m.LastSteadyState.After(t)
+}
+
+// RecordMember registers a contact of kind `event` from node `id` at time `now`.
+//
+// To limit etcd churn from polling nodes, contacts which do not change the node
+// state are only written once contact times are older than `memberSeenResolution`.
+func (m *Manager) RecordMember(ctx context.Context, id string, now time.Time, event MemberEvent) error {
+	return m.updateMember(ctx, id, func(member *Member) bool {
+		lastSeen := member.LastSeen
+		changed := member.Observe(now, event)
+		return changed || now.Sub(lastSeen) >= memberSeenResolution
+	})
+}
+
+// updateMember performs a read-modify-write cycle on the registry entry of node
+// `id`, retrying on conflicting writes.
+//
+// `mutate` is applied to the current entry (zero if absent) and returns whether
+// it needs to be written back.
+func (m *Manager) updateMember(ctx context.Context, id string, mutate func(*Member) bool) error {
+	if m == nil {
+		return ErrNilManager
+	}
+
+	key := m.memberKey(id)
+	for attempt := 0; ; attempt++ {
+		resp, err := m.client.Get(ctx, key)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrUnavailable, err)
+		}
+
+		member := Member{}
+		var modRevision int64
+		for _, kv := range resp.Kvs {
+			// Corrupt entries are left for operators to inspect, not overwritten.
+			if err := json.Unmarshal(kv.Value, &member); err != nil {
+				return fmt.Errorf("%w: member %q: %s", ErrCorrupt, id, err)
+			}
+			modRevision = kv.ModRevision
+		}
+		if !mutate(&member) {
+			return nil
+		}
+
+		data, err := json.Marshal(member)
+		if err != nil {
+			return err
+		}
+		// A zero mod revision matches a missing key.
+		txnResp, err := m.client.Txn(ctx).If(
+			clientv3.Compare(clientv3.ModRevision(key), "=", modRevision),
+		).Then(
+			clientv3.OpPut(key, string(data)),
+		).Commit()
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrUnavailable, err)
+		}
+		if txnResp.Succeeded {
+			return nil
+		}
+
+		if attempt+1 >= maxTxnAttempts {
+			return fmt.Errorf("%w: member %q", ErrConflict, id)
+		}
+		if err := retryBackoff(ctx, attempt); err != nil {
+			return ErrConflict
+		}
+	}
+}
+
+// Members returns all registered members of the group, by node ID.
+//
+// Corrupt entries are skipped with a warning, so that they do not hide the others.
+func (m *Manager) Members(ctx context.Context) (map[string]Member, error) {
+	if m == nil {
+		return nil, ErrNilManager
+	}
+
+	resp, err := m.client.Get(ctx, m.membersPrefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnavailable, err)
+	}
+
+	members := make(map[string]Member, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		id, err := url.QueryUnescape(strings.TrimPrefix(string(kv.Key), m.membersPrefix))
+		if err != nil {
+			skipCorruptMember(m.group, string(kv.Key), err)
+			continue
+		}
+		member := Member{}
+		if err := json.Unmarshal(kv.Value, &member); err != nil {
+			skipCorruptMember(m.group, string(kv.Key), err)
+			continue
+		}
+		members[id] = member
+	}
+
+	return members, nil
+}
+
+// skipCorruptMember logs a corrupt registry entry at `key`, which is skipped.
+func skipCorruptMember(group string, key string, err error) {
+	logrus.WithFields(logrus.Fields{
+		"group":  group,
+		"key":    key,
+		"reason": err.Error(),
+	}).Warn("skipping corrupt group member entry")
+}
+
+// memberKey returns the registry key for node `id`.
+func (m *Manager) memberKey(id string) string {
+	return m.membersPrefix + url.QueryEscape(id)
+}
+
+// StaleMembers returns the sorted IDs of members not seen since `timeout`
+// before `now` (zero means members never go stale).
+func StaleMembers(members map[string]Member, now time.Time, timeout time.Duration) []string {
+	stale := []string{}
+	if timeout == 0 {
+		return stale
+	}
+
+	for id, member := range members {
+		if now.Sub(member.LastSeen) > timeout {
+			stale = append(stale, id)
+		}
+	}
+	sort.Strings(stale)
+
+	return stale
+}
+
+// Node states, as reported in `Node`.
+const (
+	// NodeLocked is the state of a node holding a slot.
+	NodeLocked = "locked"
+	// NodeWaiting is the state of a node in the wait queue.
+	NodeWaiting = "waiting"
+	// NodeIdle is the state of a node neither holding nor waiting for a slot.
+	NodeIdle = "idle"
+)
+
+// Node holds registry details and the current lock state of a group member.
+type Node struct {
+	// Group is the node group.
+	Group string `json:"group"`
+	// ID is the node ID.
+	ID string `json:"id"`
+	// State is the current lock state of the node.
+	State string `json:"state"`
+	Member
+}
+
+// Nodes returns all registered members of the group with their current lock
+// state, sorted by ID.
+func (m *Manager) Nodes(ctx context.Context) ([]Node, error) {
+	members, err := m.Members(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sem, err := m.FetchSemaphore(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make([]Node, 0, len(members))
+	for id, member := range members {
+		nodes = append(nodes, Node{
+			Group:  m.group,
+			ID:     id,
+			State:  sem.NodeState(id),
+			Member: member,
+		})
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].ID < nodes[j].ID
+	})
+
+	return nodes, nil
+}
+
+// NodeState returns the current lock state of node `id`.
+func (s *Semaphore) NodeState(id string) string {
+	if s == nil {
+		return NodeIdle
+	}
+
+	loc := sort.SearchStrings(s.Holders, id)
+	if loc < len(s.Holders) && s.Holders[loc] == id {
+		return NodeLocked
+	}
+	if s.QueuePosition(id) > 0 {
+		return NodeWaiting
+	}
+	return NodeIdle
+}
+
+// FilterNotRebooted returns the nodes which did not complete a reboot within
+// `period` before `now` (zero means no filtering).
+func FilterNotRebooted(nodes []Node, now time.Time, period time.Duration) []Node {
+	if period == 0 {
+		return nodes
+	}
+
+	filtered := []Node{}
+	for _, node := range nodes {
+		if !node.RebootedSince(now.Add(-period)) {
+			filtered = append(filtered, node)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,76 @@
+package lock
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMemberObserve(t *testing.T) {
+	start := time.Date(2021, time.March, 1, 12, 0, 0, 0, time.UTC)
+	member := Member{}
+
+	if !member.Observe(start, MemberSeen) {
+		t.Error("first contact not reported as a change")
+	}
+	if member.Observe(start.Add(time.Second), MemberSeen) {
+		t.Error("repeated contact reported as a change")
+	}
+	if !member.FirstSeen.Equal(start) || member.Rebooting() {
+		t.Errorf("unexpected member: %+v", member)
+	}
+
+	// Repeated grants keep the original grant time.
+	if !member.Observe(start.Add(time.Minute), MemberGranted) {
+		t.Error("grant not reported as a change")
+	}
+	if member.Observe(start.Add(2*time.Minute), MemberGranted) {
+		t.Error("repeated grant reported as a change")
+	}
+	if !member.LastGrant.Equal(start.Add(time.Minute)) || !member.Rebooting() {
+		t.Errorf("unexpected member: %+v", member)
+	}
+
+	if !member.Observe(start.Add(6*time.Minute), MemberSteady) {
+		t.Error("completed reboot not reported as a change")
+	}
+	expected := Member{
+		FirstSeen:       start,
+		LastSeen:        start.Add(6 * time.Minute),
+		LastGrant:       start.Add(time.Minute),
+		LastSteadyState: start.Add(6 * time.Minute),
+		LastRebootSecs:  300,
+		Reboots:         1,
+	}
+	if !reflect.DeepEqual(member, expected) {
+		t.Errorf("expected %+v, got %+v", expected, member)
+	}
+	if member.Rebooting() || !member.RebootedSince(start) || member.RebootedSince(start.Add(time.Hour)) {
+		t.Errorf("unexpected reboot state: %+v", member)
+	}
+
+	// Steady-state reports without a grant do not count as reboots.
+	if member.Observe(start.Add(time.Hour), MemberSteady) {
+		t.Error("steady-state report without a grant reported as a change")
+	}
+	if member.Reboots != 1 || member.LastRebootSecs != 300 {
+		t.Errorf("unexpected member: %+v", member)
+	}
+}
+
+func TestStaleMembers(t *testing.T) {
+	now := time.Date(2021, time.March, 1, 12, 0, 0, 0, time.UTC)
+	members := map[string]Member{
+		"b": {LastSeen: now.Add(-48 * time.Hour)},
+		"a": {LastSeen: now.Add(-25 * time.Hour)},
+		"c": {LastSeen: now.Add(-time.Hour)},
+	}
+
+	if stale := StaleMembers(members, now, 0); len(stale) != 0 {
+		t.Errorf("unexpected stale members: %v", stale)
+	}
+	stale := StaleMembers(members, now, 24*time.Hour)
+	if !reflect.DeepEqual(stale, []string{"a", "b"}) {
+		t.Errorf("unexpected stale members: %v", stale)
+	}
+}
@@ -0,0 +1,181 @@
+package lock
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// waiterSeenResolution is the resolution of waiter contact times.
+const waiterSeenResolution = time.Minute
+
+// Waiter is a node waiting for a semaphore slot, in first-come-first-served order.
+type Waiter struct {
+	// ID is the waiting node ID.
+	ID string `json:"id"`
+	// EnqueuedAt is the time at which the node started waiting.
+	EnqueuedAt time.Time `json:"enqueued_at"`
+	// LastSeen is the time of the latest lock request from the node (up to
+	// `waiterSeenResolution`).
+	LastSeen time.Time `json:"last_seen"`
+	// Priority is the node priority, higher values are granted a slot first.
+	Priority int `json:"priority,omitempty"`
+	// Weight is the number of slots the node will consume (zero means one).
+	Weight uint64 `json:"weight,omitempty"`
+}
+
+// EffectivePriority returns the waiter priority at time `now`, increased by one
+// for each `aging` interval spent waiting (so that low priorities cannot starve).
+func (w Waiter) EffectivePriority(now time.Time, aging time.Duration) int {
+	if aging <= 0 || now.Before(w.EnqueuedAt) {
+		return w.Priority
+	}
+	return w.Priority + int(now.Sub(w.EnqueuedAt)/aging)
+}
+
+// WaitError is returned when no slot can be granted to a node, which has been
+// recorded in the wait queue instead.
+type WaitError struct {
+	// Slots is the total number of semaphore slots.
+	Slots uint64
+	// Position is the 1-based position of the node in the wait queue.
+	Position int
+	// Waiters is the total number of waiting nodes.
+	Waiters int
+}
+
+// Error returns a human-friendly description of the queue position.
+func (e *WaitError) Error() string {
+	return fmt.Sprintf("%s: all %d semaphore slots currently locked or reserved, waiting at queue position %d of %d", ErrSlotsFull, e.Slots, e.Position, e.Waiters)
+}
+
+// Is makes WaitError match ErrSlotsFull.
+func (e *WaitError) Is(target error) bool {
+	return target == ErrSlotsFull
+}
+
+// RankedWaiters returns all waiters in the order in which they will be granted
+// a slot at time `now`: by effective priority first, then by arrival time.
+func (s *Semaphore) RankedWaiters(now time.Time, aging time.Duration) []Waiter {
+	if s == nil {
+		return nil
+	}
+
+	ranked := make([]Waiter, len(s.Waiters))
+	copy(ranked, s.Waiters)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].EffectivePriority(now, aging) > ranked[j].EffectivePriority(now, aging)
+	})
+
+	return ranked
+}
+
+// QueuePosition returns the 1-based position of `id` in the wait queue (in
+// arrival order), or 0 if not waiting.
+func (s *Semaphore) QueuePosition(id string) int {
+	if s == nil {
+		return 0
+	}
+
+	for i, waiter := range s.Waiters {
+		if waiter.ID == id {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// admitWaiter checks whether `id` may take a free slot ahead of other waiters.
+//
+// Waiters not seen within `opts.WaiterTimeout` are dropped first. Then `id`
+// is added to the queue (or its entry refreshed), and it is admitted only if
+// the weights of all waiters ranked ahead, plus its own, fit within the free
+// slots. Otherwise a WaitError is returned, and `id` stays in the queue.
+func (s *Semaphore) admitWaiter(id string, now time.Time, opts LockOptions) error {
+	s.pruneWaiters(now, opts.WaiterTimeout)
+	s.enqueueWaiter(id, now, opts)
+
+	free := s.FreeWeight()
+	rank := 0
+	needed := uint64(0)
+	for i, waiter := range s.RankedWaiters(now, opts.PriorityAging) {
+		needed += normalizeWeight(waiter.Weight)
+		if waiter.ID == id {
+			rank = i + 1
+			break
+		}
+	}
+	// An idle semaphore can always be locked by its first waiter, even if heavier.
+	idle := len(s.Holders) == 0 && s.TotalSlots > 0
+	if needed <= free || (rank == 1 && idle) {
+		return nil
+	}
+
+	return &WaitError{
+		Slots:    s.TotalSlots,
+		Position: rank,
+		Waiters:  len(s.Waiters),
+	}
+}
+
+// enqueueWaiter adds `id` to the wait queue, or refreshes its entry if already
+// waiting, returning whether the queue changed. Holders are never queued.
+//
+// To limit etcd churn from polling nodes, an unchanged entry is only refreshed
+// once its contact time is older than `waiterSeenResolution` (capped to half of
+// `opts.WaiterTimeout`, so that polling waiters are never pruned).
+func (s *Semaphore) enqueueWaiter(id string, now time.Time, opts LockOptions) bool {
+	if s.NodeState(id) == NodeLocked {
+		return false
+	}
+
+	weight := uint64(0)
+	if opts.Weight > 1 {
+		weight = opts.Weight
+	}
+	position := s.QueuePosition(id)
+	if position == 0 {
+		s.Waiters = append(s.Waiters, Waiter{
+			ID:         id,
+			EnqueuedAt: now.UTC(),
+			LastSeen:   now.UTC(),
+			Priority:   opts.Priority,
+			Weight:     weight,
+		})
+		return true
+	}
+
+	waiter := &s.Waiters[position-1]
+	resolution := waiterSeenResolution
+	if opts.WaiterTimeout > 0 && opts.WaiterTimeout/2 < resolution {
+		resolution = opts.WaiterTimeout / 2
+	}
+	if waiter.Priority == opts.Priority && waiter.Weight == weight && now.Sub(waiter.LastSeen) < resolution {
+		return false
+	}
+	waiter.LastSeen = now.UTC()
+	waiter.Priority = opts.Priority
+	waiter.Weight = weight
+
+	return true
+}
+
+// pruneWaiters drops all waiters not seen within `timeout` before `now`.
+func (s *Semaphore) pruneWaiters(now time.Time, timeout time.Duration) {
+	waiters := s.Waiters[:0]
+	for _, waiter := range s.Waiters {
+		if now.Sub(waiter.LastSeen) <= timeout {
+			waiters = append(waiters, waiter)
+		}
+	}
+	s.Waiters = waiters
+}
+
+// removeWaiter removes `id` from the wait queue, if present.
+func (s *Semaphore) removeWaiter(id string) {
+	position := s.QueuePosition(id)
+	if position == 0 {
+		return
+	}
+	s.Waiters = append(s.Waiters[:position-1], s.Waiters[position:]...)
+}
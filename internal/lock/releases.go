@@ -0,0 +1,70 @@
+package lock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+const (
+	releasesTemplate = "com.coreos.airlock/groups/%s/v1/releases/"
+)
+
+// Release holds the record of a lock force-released by an operator.
+type Release struct {
+	// ReleasedAt is the time at which the lock was released.
+	ReleasedAt time.Time `json:"released_at"`
+	// By is the operator who released the lock.
+	By string `json:"by,omitempty"`
+	// Reason is a human-friendly reason for the release (optional).
+	Reason string `json:"reason,omitempty"`
+	// Lease is the released lease (nil for holders without lease details).
+	Lease *Lease `json:"lease,omitempty"`
+}
+
+// RecordRelease stores the record of a lock force-released from holder `id`,
+// replacing any previous record for `id`.
+func (m *Manager) RecordRelease(ctx context.Context, id string, release Release) error {
+	if m == nil {
+		return ErrNilManager
+	}
+
+	data, err := json.Marshal(release)
+	if err != nil {
+		return err
+	}
+	if _, err := m.client.Put(ctx, m.releaseKey(id), string(data)); err != nil {
+		return fmt.Errorf("%w: %s", ErrUnavailable, err)
+	}
+
+	return nil
+}
+
+// LastRelease returns the record of the last lock force-released from holder
+// `id`, or nil.
+func (m *Manager) LastRelease(ctx context.Context, id string) (*Release, error) {
+	if m == nil {
+		return nil, ErrNilManager
+	}
+
+	resp, err := m.client.Get(ctx, m.releaseKey(id))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnavailable, err)
+	}
+	for _, kv := range resp.Kvs {
+		release := Release{}
+		if err := json.Unmarshal(kv.Value, &release); err != nil {
+			return nil, fmt.Errorf("%w: release %q: %s", ErrCorrupt, id, err)
+		}
+		return &release, nil
+	}
+
+	return nil, nil
+}
+
+// releaseKey returns the release record key for holder `id`.
+func (m *Manager) releaseKey(id string) string {
+	return m.releasesPrefix + url.QueryEscape(id)
+}
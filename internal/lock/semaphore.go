@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"sort"
+	"time"
 )
 
 var (
@@ -14,18 +15,94 @@ var (
 
 // Semaphore is a struct representation of the information held by the semaphore
 type Semaphore struct {
-	TotalSlots uint64   `json:"total_slots"`
-	Holders    []string `json:"holders"`
+	TotalSlots uint64           `json:"total_slots"`
+	Holders    []string         `json:"holders"`
+	Leases     map[string]Lease `json:"leases,omitempty"`
+	Waiters    []Waiter         `json:"waiters,omitempty"`
+	Pause      *Pause           `json:"paused,omitempty"`
+}
+
+// Pause holds details of a paused semaphore, which refuses new holders.
+type Pause struct {
+	// Since is the time at which the semaphore was paused.
+	Since time.Time `json:"since"`
+	// Until is the time at which the pause expires (nil means never).
+	Until *time.Time `json:"until,omitempty"`
+	// Reason is a human-friendly reason for the pause (optional).
+	Reason string `json:"reason,omitempty"`
+}
+
+// Lease holds lock details for a single semaphore holder.
+type Lease struct {
+	// AcquiredAt is the time at which the lock was acquired.
+	AcquiredAt time.Time `json:"acquired_at"`
+	// MaxHoldSecs is the maximum lock duration in seconds (zero means unlimited).
+	MaxHoldSecs uint64 `json:"max_hold_secs,omitempty"`
+	// Weight is the number of slots consumed by the holder (zero means one).
+	Weight uint64 `json:"weight,omitempty"`
+	// By is the operator who took the lock manually (empty for nodes).
+	By string `json:"by,omitempty"`
+	// Reason is a human-friendly reason for a manual lock (optional).
+	Reason string `json:"reason,omitempty"`
+}
+
+// LockOptions holds optional parameters for a lock request.
+type LockOptions struct {
+	// Now is the time of the request (zero means current time).
+	Now time.Time
+	// MaxHold is the maximum lock duration (zero means unlimited).
+	MaxHold time.Duration
+	// WaiterTimeout is the time after which a waiting node that stopped
+	// polling loses its queue position (zero disables the wait queue).
+	WaiterTimeout time.Duration
+	// Priority is the node priority in the wait queue, higher values first.
+	Priority int
+	// PriorityAging is the waiting time after which a waiter priority is
+	// increased by one (zero disables aging).
+	PriorityAging time.Duration
+	// Weight is the number of slots consumed by the node (zero means one).
+	Weight uint64
+	// By is the operator taking the lock manually, recorded in the lease (optional).
+	By string
+	// Reason is the reason for a manual lock, recorded in the lease (optional).
+	Reason string
 }
 
 // NewSemaphore returns a new empty semaphore.
 func NewSemaphore(slots uint64) (sem *Semaphore) {
-	return &Semaphore{slots, []string{}}
+	return &Semaphore{
+		TotalSlots: slots,
+		Holders:    []string{},
+	}
+}
+
+// SlotWeight returns the number of slots consumed by the lease holder.
+func (l Lease) SlotWeight() uint64 {
+	return normalizeWeight(l.Weight)
+}
+
+// Expired returns whether the lease is overdue at time `now`.
+func (l Lease) Expired(now time.Time) bool {
+	if l.MaxHoldSecs == 0 {
+		return false
+	}
+	deadline := l.AcquiredAt.Add(time.Duration(l.MaxHoldSecs) * time.Second)
+	return now.After(deadline)
 }
 
 // RecursiveLock adds holder `id` to the semaphore, or returns an error if
 // the semaphore is already at maximum capacity.
 func (s *Semaphore) RecursiveLock(id string) (bool, error) {
+	return s.RecursiveLockWithOptions(id, LockOptions{})
+}
+
+// RecursiveLockWithOptions adds holder `id` to the semaphore with the given
+// options, or returns an error if the semaphore is already at maximum capacity.
+//
+// If the wait queue is enabled, slots are granted in priority and queue order and a
+// WaitError is returned when `id` has been queued (the semaphore is modified).
+// While paused, `id` is queued as well, without pruning the queue.
+func (s *Semaphore) RecursiveLockWithOptions(id string, opts LockOptions) (bool, error) {
 	if s == nil {
 		return false, ErrNilSemaphore
 	}
@@ -36,14 +113,47 @@ func (s *Semaphore) RecursiveLock(id string) (bool, error) {
 		return true, nil
 	}
 
-	if err := s.addHolder(id); err != nil {
+	now := opts.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	if pause := s.ActivePause(now); pause != nil {
+		// Keep the queue position while paused, so that it survives the pause.
+		if opts.WaiterTimeout > 0 {
+			s.enqueueWaiter(id, now, opts)
+		}
+		return false, pause.error()
+	}
+	if opts.WaiterTimeout > 0 {
+		if err := s.admitWaiter(id, now, opts); err != nil {
+			return false, err
+		}
+	}
+
+	weight := normalizeWeight(opts.Weight)
+	if err := s.addHolder(id, weight); err != nil {
 		return false, err
 	}
+	s.removeWaiter(id)
+
+	if s.Leases == nil {
+		s.Leases = make(map[string]Lease)
+	}
+	lease := Lease{
+		AcquiredAt:  now.UTC(),
+		MaxHoldSecs: uint64(opts.MaxHold / time.Second),
+		By:          opts.By,
+		Reason:      opts.Reason,
+	}
+	if weight > 1 {
+		lease.Weight = weight
+	}
+	s.Leases[id] = lease
 
 	return false, nil
 }
 
-// UnlockIfHeld removes holder `id` from the semaphore, if present.
+// UnlockIfHeld removes holder `id` from the semaphore (and from the wait queue), if present.
 func (s *Semaphore) UnlockIfHeld(h string) error {
 	if s == nil {
 		return ErrNilSemaphore
@@ -53,10 +163,169 @@ func (s *Semaphore) UnlockIfHeld(h string) error {
 	if err != nil {
 		return err
 	}
+	s.removeWaiter(h)
 
 	return nil
 }
 
+// ExpireHolders removes all holders whose lease is overdue at time `now`,
+// returning their IDs.
+func (s *Semaphore) ExpireHolders(now time.Time) ([]string, error) {
+	if s == nil {
+		return nil, ErrNilSemaphore
+	}
+
+	expired := []string{}
+	for _, h := range s.Holders {
+		lease, ok := s.Leases[h]
+		if ok && lease.Expired(now) {
+			expired = append(expired, h)
+		}
+	}
+	for _, h := range expired {
+		if _, err := s.removeHolderIfPresent(h); err != nil {
+			return nil, err
+		}
+	}
+
+	return expired, nil
+}
+
+// ActivePause returns the pause in effect at time `now`, or nil.
+func (s *Semaphore) ActivePause(now time.Time) *Pause {
+	if s == nil || s.Pause == nil || s.Pause.Expired(now) {
+		return nil
+	}
+	return s.Pause
+}
+
+// SetPause pauses the semaphore from `now`, until `until` (zero means no
+// expiry), replacing any existing pause.
+func (s *Semaphore) SetPause(now time.Time, until time.Time, reason string) error {
+	if s == nil {
+		return ErrNilSemaphore
+	}
+
+	pause := Pause{
+		Since:  now.UTC(),
+		Reason: reason,
+	}
+	if !until.IsZero() {
+		utc := until.UTC()
+		pause.Until = &utc
+	}
+	s.Pause = &pause
+	return nil
+}
+
+// Resume removes any pause, returning whether the semaphore was paused.
+func (s *Semaphore) Resume() (bool, error) {
+	if s == nil {
+		return false, ErrNilSemaphore
+	}
+
+	paused := s.Pause != nil
+	s.Pause = nil
+	return paused, nil
+}
+
+// Expired returns whether the pause is over at time `now`.
+func (p Pause) Expired(now time.Time) bool {
+	return p.Until != nil && !now.Before(*p.Until)
+}
+
+// error returns the error for a lock refused by the pause.
+func (p Pause) error() error {
+	details := ""
+	if p.Until != nil {
+		details += fmt.Sprintf(" until %s", p.Until.Format(time.RFC3339))
+	}
+	if p.Reason != "" {
+		details += fmt.Sprintf(": %s", p.Reason)
+	}
+	return fmt.Errorf("%w since %s%s", ErrPaused, p.Since.Format(time.RFC3339), details)
+}
+
+// UsedWeight returns the number of slots consumed by all holders.
+func (s *Semaphore) UsedWeight() uint64 {
+	if s == nil {
+		return 0
+	}
+
+	used := uint64(0)
+	for _, h := range s.Holders {
+		used += s.Leases[h].SlotWeight()
+	}
+	return used
+}
+
+// OldestHolderAge returns the time elapsed at `now` since the oldest current
+// lease was acquired (zero if there is no holder with lease details).
+func (s *Semaphore) OldestHolderAge(now time.Time) time.Duration {
+	if s == nil {
+		return 0
+	}
+
+	var oldest time.Duration
+	for _, h := range s.Holders {
+		lease, ok := s.Leases[h]
+		if !ok {
+			continue
+		}
+		if age := now.Sub(lease.AcquiredAt); age > oldest {
+			oldest = age
+		}
+	}
+	return oldest
+}
+
+// FreeWeight returns the number of slots not consumed by any holder.
+func (s *Semaphore) FreeWeight() uint64 {
+	used := s.UsedWeight()
+	if s == nil || used >= s.TotalSlots {
+		return 0
+	}
+	return s.TotalSlots - used
+}
+
+// SetTotalSlots changes the number of slots of the semaphore, returning whether
+// it changed. Existing holders are always kept, even if above the new capacity.
+func (s *Semaphore) SetTotalSlots(slots uint64) (bool, error) {
+	if s == nil {
+		return false, ErrNilSemaphore
+	}
+	if s.TotalSlots == slots {
+		return false, nil
+	}
+
+	s.TotalSlots = slots
+	return true, nil
+}
+
+// clone returns a deep copy of the semaphore.
+func (s *Semaphore) clone() *Semaphore {
+	if s == nil {
+		return nil
+	}
+
+	c := *s
+	c.Holders = append([]string{}, s.Holders...)
+	if s.Leases != nil {
+		c.Leases = make(map[string]Lease, len(s.Leases))
+		for id, lease := range s.Leases {
+			c.Leases[id] = lease
+		}
+	}
+	if s.Waiters != nil {
+		c.Waiters = append([]Waiter{}, s.Waiters...)
+	}
+	if s.Pause != nil {
+		pause := *s.Pause
+		c.Pause = &pause
+	}
+	return &c
+}
+
 // String returns a JSON representation of the semaphore.
 func (s *Semaphore) String() (string, error) {
 	if s == nil {
@@ -71,13 +340,54 @@ func (s *Semaphore) String() (string, error) {
 	return string(b), nil
 }
 
-// addHolder adds a holder with id h to the list of holders in the semaphore
-func (s *Semaphore) addHolder(h string) error {
+// normalizeWeight returns the actual weight for a (possibly unset) weight.
+func normalizeWeight(weight uint64) uint64 {
+	if weight == 0 {
+		return 1
+	}
+	return weight
+}
+
+// adoptLegacyHolders assigns a lease starting at `now` to all holders
+// without lease details. It returns whether any holder was adopted.
+func (s *Semaphore) adoptLegacyHolders(now time.Time, maxHold time.Duration) bool {
+	if s == nil || maxHold == 0 {
+		return false
+	}
+
+	adopted := false
+	for _, h := range s.Holders {
+		if _, ok := s.Leases[h]; ok {
+			continue
+		}
+		if s.Leases == nil {
+			s.Leases = make(map[string]Lease)
+		}
+		s.Leases[h] = Lease{
+			AcquiredAt:  now.UTC(),
+			MaxHoldSecs: uint64(maxHold / time.Second),
+		}
+		adopted = true
+	}
+
+	return adopted
+}
+
+// addHolder adds a holder with id h to the list of holders in the semaphore,
+// if the sum of held weights stays within capacity.
+//
+// A holder heavier than the whole capacity is only admitted in an otherwise
+// unlocked semaphore, so that it is never starved.
+func (s *Semaphore) addHolder(h string, weight uint64) error {
 	if s == nil {
 		return ErrNilSemaphore
 	}
-	if len(s.Holders) >= int(s.TotalSlots) {
-		return fmt.Errorf("all %d semaphore slots currently locked", s.TotalSlots)
+	used := s.UsedWeight()
+	if used+weight > s.TotalSlots && (len(s.Holders) > 0 || s.TotalSlots == 0) {
+		if weight == 1 {
+			return fmt.Errorf("%w: all %d semaphore slots currently locked", ErrSlotsFull, s.TotalSlots)
+		}
+		return fmt.Errorf("%w: %d of %d semaphore slots currently locked, %d requested", ErrSlotsFull, used, s.TotalSlots, weight)
 	}
 
 	loc := sort.SearchStrings(s.Holders, h)
@@ -101,6 +411,7 @@ func (s *Semaphore) removeHolderIfPresent(h string) (bool, error) {
 	loc := sort.SearchStrings(s.Holders, h)
 	if loc < len(s.Holders) && s.Holders[loc] == h {
 		s.Holders = append(s.Holders[:loc], s.Holders[loc+1:]...)
+		delete(s.Leases, h)
 		return true, nil
 	}
 
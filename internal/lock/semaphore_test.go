@@ -1,8 +1,11 @@
 package lock
 
 import (
+	"encoding/json"
+	"errors"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestSingleLock(t *testing.T) {
@@ -84,3 +87,436 @@ func TestHolderOrdering(t *testing.T) {
 		t.Error("unexpected ordering")
 	}
 }
+
+func TestExpireHolders(t *testing.T) {
+	sem := NewSemaphore(3)
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if _, err := sem.RecursiveLockWithOptions("a", LockOptions{Now: start, MaxHold: time.Hour}); err != nil {
+		t.Error(err)
+	}
+	if _, err := sem.RecursiveLockWithOptions("b", LockOptions{Now: start, MaxHold: 2 * time.Hour}); err != nil {
+		t.Error(err)
+	}
+	if _, err := sem.RecursiveLockWithOptions("c", LockOptions{Now: start}); err != nil {
+		t.Error(err)
+	}
+
+	expired, err := sem.ExpireHolders(start.Add(90 * time.Minute))
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(expired, []string{"a"}) {
+		t.Errorf("unexpected expired holders: %v", expired)
+	}
+	if !reflect.DeepEqual(sem.Holders, []string{"b", "c"}) {
+		t.Errorf("unexpected holders: %v", sem.Holders)
+	}
+	if _, ok := sem.Leases["a"]; ok {
+		t.Error("lease for a not removed")
+	}
+
+	expired, err = sem.ExpireHolders(start.Add(48 * time.Hour))
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(expired, []string{"b"}) {
+		t.Errorf("unexpected expired holders: %v", expired)
+	}
+	if !reflect.DeepEqual(sem.Holders, []string{"c"}) {
+		t.Errorf("unexpected holders: %v", sem.Holders)
+	}
+}
+
+func TestLegacyHolders(t *testing.T) {
+	sem := &Semaphore{}
+	if err := json.Unmarshal([]byte(`{"total_slots":2,"holders":["a"]}`), sem); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	expired, err := sem.ExpireHolders(now.Add(time.Hour))
+	if err != nil {
+		t.Error(err)
+	}
+	if len(expired) != 0 {
+		t.Errorf("unexpected expired holders: %v", expired)
+	}
+
+	if !sem.adoptLegacyHolders(now, time.Hour) {
+		t.Error("legacy holder not adopted")
+	}
+	expired, err = sem.ExpireHolders(now.Add(2 * time.Hour))
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(expired, []string{"a"}) {
+		t.Errorf("unexpected expired holders: %v", expired)
+	}
+}
+
+func TestSlotsFull(t *testing.T) {
+	sem := NewSemaphore(1)
+
+	if _, err := sem.RecursiveLock("a"); err != nil {
+		t.Error(err)
+	}
+	_, err := sem.RecursiveLock("b")
+	if !errors.Is(err, ErrSlotsFull) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(sem.Holders, []string{"a"}) {
+		t.Errorf("unexpected holders: %v", sem.Holders)
+	}
+}
+
+func TestSetTotalSlots(t *testing.T) {
+	sem := NewSemaphore(2)
+	if _, err := sem.RecursiveLock("a"); err != nil {
+		t.Error(err)
+	}
+	if _, err := sem.RecursiveLock("b"); err != nil {
+		t.Error(err)
+	}
+
+	changed, err := sem.SetTotalSlots(1)
+	if err != nil {
+		t.Error(err)
+	}
+	if !changed || sem.TotalSlots != 1 {
+		t.Errorf("unexpected semaphore size: %d", sem.TotalSlots)
+	}
+	if !reflect.DeepEqual(sem.Holders, []string{"a", "b"}) {
+		t.Errorf("unexpected holders: %v", sem.Holders)
+	}
+
+	changed, err = sem.SetTotalSlots(1)
+	if err != nil {
+		t.Error(err)
+	}
+	if changed {
+		t.Error("unexpected change")
+	}
+}
+
+func TestWaitQueue(t *testing.T) {
+	sem := NewSemaphore(1)
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	opts := func(offset time.Duration) LockOptions {
+		return LockOptions{Now: start.Add(offset), WaiterTimeout: 10 * time.Minute}
+	}
+
+	if _, err := sem.RecursiveLockWithOptions("a", opts(0)); err != nil {
+		t.Error(err)
+	}
+
+	// b and c queue up, in order.
+	_, err := sem.RecursiveLockWithOptions("b", opts(time.Minute))
+	var waitErr *WaitError
+	if !errors.As(err, &waitErr) || waitErr.Position != 1 {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if !errors.Is(err, ErrSlotsFull) {
+		t.Errorf("unexpected error kind: %v", err)
+	}
+	_, err = sem.RecursiveLockWithOptions("c", opts(2*time.Minute))
+	if !errors.As(err, &waitErr) || waitErr.Position != 2 || waitErr.Waiters != 2 {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// Once a slot is free, c cannot jump ahead of b.
+	if err := sem.UnlockIfHeld("a"); err != nil {
+		t.Error(err)
+	}
+	if _, err := sem.RecursiveLockWithOptions("c", opts(3*time.Minute)); !errors.Is(err, ErrSlotsFull) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := sem.RecursiveLockWithOptions("b", opts(4*time.Minute)); err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(sem.Holders, []string{"b"}) || sem.QueuePosition("c") != 1 {
+		t.Errorf("unexpected state: %v %v", sem.Holders, sem.Waiters)
+	}
+}
+
+func TestStaleWaiters(t *testing.T) {
+	sem := NewSemaphore(1)
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	opts := func(offset time.Duration) LockOptions {
+		return LockOptions{Now: start.Add(offset), WaiterTimeout: 10 * time.Minute}
+	}
+
+	if _, err := sem.RecursiveLockWithOptions("a", opts(0)); err != nil {
+		t.Error(err)
+	}
+	if _, err := sem.RecursiveLockWithOptions("b", opts(time.Minute)); !errors.Is(err, ErrSlotsFull) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := sem.UnlockIfHeld("a"); err != nil {
+		t.Error(err)
+	}
+
+	// b stopped polling, so c gets the slot.
+	if _, err := sem.RecursiveLockWithOptions("c", opts(20*time.Minute)); err != nil {
+		t.Error(err)
+	}
+	if len(sem.Waiters) != 0 {
+		t.Errorf("unexpected waiters: %v", sem.Waiters)
+	}
+}
+
+func TestWaitersAcrossClosedPeriods(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	opts := func(offset time.Duration) LockOptions {
+		return LockOptions{Now: start.Add(offset), WaiterTimeout: 10 * time.Minute}
+	}
+
+	// A closed maintenance window refuses all locks for two hours, while
+	// a and b keep polling: they keep their queue position meanwhile.
+	sem := NewSemaphore(1)
+	if _, err := sem.RecursiveLockWithOptions("x", opts(0)); err != nil {
+		t.Error(err)
+	}
+	for _, id := range []string{"a", "b"} {
+		if _, err := sem.RecursiveLockWithOptions(id, opts(time.Minute)); !errors.Is(err, ErrSlotsFull) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if err := sem.UnlockIfHeld("x"); err != nil {
+		t.Error(err)
+	}
+	for offset := 5 * time.Minute; offset < 2*time.Hour; offset += 5 * time.Minute {
+		sem.enqueueWaiter("c", opts(offset).Now, opts(offset))
+		sem.enqueueWaiter("b", opts(offset).Now, opts(offset))
+		sem.enqueueWaiter("a", opts(offset).Now, opts(offset))
+	}
+	if _, err := sem.RecursiveLockWithOptions("c", opts(2*time.Hour)); !errors.Is(err, ErrSlotsFull) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := sem.RecursiveLockWithOptions("a", opts(2*time.Hour)); err != nil {
+		t.Error(err)
+	}
+	if pos := sem.QueuePosition("b"); pos != 1 {
+		t.Errorf("unexpected position for b: %d", pos)
+	}
+
+	// Paused groups queue polling nodes without pruning.
+	sem = NewSemaphore(1)
+	if err := sem.SetPause(start, time.Time{}, "incident"); err != nil {
+		t.Error(err)
+	}
+	for offset := time.Duration(0); offset < 2*time.Hour; offset += 5 * time.Minute {
+		for _, id := range []string{"a", "b"} {
+			if _, err := sem.RecursiveLockWithOptions(id, opts(offset)); !errors.Is(err, ErrPaused) {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}
+	}
+	if _, err := sem.Resume(); err != nil {
+		t.Error(err)
+	}
+	if _, err := sem.RecursiveLockWithOptions("c", opts(2*time.Hour)); !errors.Is(err, ErrSlotsFull) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := sem.RecursiveLockWithOptions("a", opts(2*time.Hour)); err != nil {
+		t.Error(err)
+	}
+	if len(sem.Waiters) != 2 || sem.QueuePosition("b") != 1 {
+		t.Errorf("unexpected waiters: %v", sem.Waiters)
+	}
+}
+
+func TestPriorityQueue(t *testing.T) {
+	sem := NewSemaphore(1)
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	opts := func(offset time.Duration, priority int) LockOptions {
+		return LockOptions{
+			Now:           start.Add(offset),
+			WaiterTimeout: 24 * time.Hour,
+			Priority:      priority,
+			PriorityAging: time.Hour,
+		}
+	}
+
+	if _, err := sem.RecursiveLockWithOptions("a", opts(0, 0)); err != nil {
+		t.Error(err)
+	}
+	if _, err := sem.RecursiveLockWithOptions("low", opts(time.Minute, 0)); !errors.Is(err, ErrSlotsFull) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	var waitErr *WaitError
+	_, err := sem.RecursiveLockWithOptions("high", opts(2*time.Minute, 2))
+	if !errors.As(err, &waitErr) || waitErr.Position != 1 {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// High priority is granted first.
+	if err := sem.UnlockIfHeld("a"); err != nil {
+		t.Error(err)
+	}
+	if _, err := sem.RecursiveLockWithOptions("low", opts(3*time.Minute, 0)); !errors.Is(err, ErrSlotsFull) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := sem.RecursiveLockWithOptions("high", opts(4*time.Minute, 2)); err != nil {
+		t.Error(err)
+	}
+
+	// After waiting long enough, low priority overtakes a new high priority waiter.
+	if err := sem.UnlockIfHeld("high"); err != nil {
+		t.Error(err)
+	}
+	if _, err := sem.RecursiveLockWithOptions("other", opts(4*time.Hour, 2)); !errors.Is(err, ErrSlotsFull) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := sem.RecursiveLockWithOptions("low", opts(4*time.Hour, 0)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWeightedSlots(t *testing.T) {
+	sem := NewSemaphore(3)
+	heavy := LockOptions{Weight: 2}
+
+	if _, err := sem.RecursiveLockWithOptions("big", heavy); err != nil {
+		t.Error(err)
+	}
+	if sem.UsedWeight() != 2 || sem.FreeWeight() != 1 {
+		t.Errorf("unexpected weights: used %d, free %d", sem.UsedWeight(), sem.FreeWeight())
+	}
+	if _, err := sem.RecursiveLockWithOptions("other", heavy); !errors.Is(err, ErrSlotsFull) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := sem.RecursiveLock("small"); err != nil {
+		t.Error(err)
+	}
+	if _, err := sem.RecursiveLock("tiny"); !errors.Is(err, ErrSlotsFull) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// A node heavier than the whole group can only lock it when idle.
+	if err := sem.UnlockIfHeld("big"); err != nil {
+		t.Error(err)
+	}
+	if _, err := sem.RecursiveLockWithOptions("huge", LockOptions{Weight: 5}); !errors.Is(err, ErrSlotsFull) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := sem.UnlockIfHeld("small"); err != nil {
+		t.Error(err)
+	}
+	if _, err := sem.RecursiveLockWithOptions("huge", LockOptions{Weight: 5}); err != nil {
+		t.Error(err)
+	}
+	if sem.UsedWeight() != 5 || sem.FreeWeight() != 0 {
+		t.Errorf("unexpected weights: used %d, free %d", sem.UsedWeight(), sem.FreeWeight())
+	}
+}
+
+func TestManualLease(t *testing.T) {
+	sem := NewSemaphore(2)
+	opts := LockOptions{By: "alice@bastion", Reason: "OPS-42 disk swap"}
+
+	if _, err := sem.RecursiveLockWithOptions("ops-42", opts); err != nil {
+		t.Fatal(err)
+	}
+	lease := sem.Leases["ops-42"]
+	if lease.By != opts.By || lease.Reason != opts.Reason {
+		t.Errorf("unexpected lease record: %+v", lease)
+	}
+
+	// Relocking keeps the original record.
+	if _, err := sem.RecursiveLockWithOptions("ops-42", LockOptions{By: "bob"}); err != nil {
+		t.Error(err)
+	}
+	if sem.Leases["ops-42"].By != opts.By {
+		t.Errorf("lease record overwritten: %+v", sem.Leases["ops-42"])
+	}
+
+	if _, err := sem.RecursiveLock("node"); err != nil {
+		t.Error(err)
+	}
+	if lease := sem.Leases["node"]; lease.By != "" || lease.Reason != "" {
+		t.Errorf("unexpected node lease record: %+v", lease)
+	}
+}
+
+func TestOldestHolderAge(t *testing.T) {
+	now := time.Date(2021, time.March, 1, 12, 0, 0, 0, time.UTC)
+	sem := NewSemaphore(3)
+
+	if age := sem.OldestHolderAge(now); age != 0 {
+		t.Errorf("unexpected age: %s", age)
+	}
+	if _, err := sem.RecursiveLockWithOptions("a", LockOptions{Now: now.Add(-time.Hour)}); err != nil {
+		t.Error(err)
+	}
+	if _, err := sem.RecursiveLockWithOptions("b", LockOptions{Now: now.Add(-2 * time.Hour)}); err != nil {
+		t.Error(err)
+	}
+	// Legacy holders without lease details are ignored.
+	sem.Holders = append(sem.Holders, "c")
+
+	if age := sem.OldestHolderAge(now); age != 2*time.Hour {
+		t.Errorf("unexpected age: %s", age)
+	}
+	if err := sem.UnlockIfHeld("b"); err != nil {
+		t.Error(err)
+	}
+	if age := sem.OldestHolderAge(now); age != time.Hour {
+		t.Errorf("unexpected age: %s", age)
+	}
+}
+
+func TestPause(t *testing.T) {
+	now := time.Date(2021, time.March, 1, 12, 0, 0, 0, time.UTC)
+	sem := NewSemaphore(2)
+
+	if _, err := sem.RecursiveLockWithOptions("a", LockOptions{Now: now}); err != nil {
+		t.Error(err)
+	}
+	if err := sem.SetPause(now, now.Add(time.Hour), "incident"); err != nil {
+		t.Error(err)
+	}
+	if sem.ActivePause(now) == nil {
+		t.Error("expected active pause")
+	}
+
+	// Existing holders are kept, new ones refused.
+	if _, err := sem.RecursiveLockWithOptions("a", LockOptions{Now: now}); err != nil {
+		t.Error(err)
+	}
+	if _, err := sem.RecursiveLockWithOptions("b", LockOptions{Now: now}); !errors.Is(err, ErrPaused) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := sem.UnlockIfHeld("a"); err != nil {
+		t.Error(err)
+	}
+
+	// The pause survives serialization, and eventually expires.
+	out, err := sem.String()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed := Semaphore{}
+	if err := json.Unmarshal([]byte(out), &parsed); err != nil {
+		t.Fatal(err)
+	}
+	if pause := parsed.ActivePause(now.Add(59 * time.Minute)); pause == nil || pause.Reason != "incident" {
+		t.Errorf("unexpected pause: %v", pause)
+	}
+	if _, err := parsed.RecursiveLockWithOptions("b", LockOptions{Now: now.Add(time.Hour)}); err != nil {
+		t.Error(err)
+	}
+
+	if err := sem.SetPause(now, time.Time{}, ""); err != nil {
+		t.Error(err)
+	}
+	if sem.ActivePause(now.Add(24*time.Hour)) == nil {
+		t.Error("expected pause without expiry")
+	}
+	if paused, err := sem.Resume(); err != nil || !paused {
+		t.Errorf("unexpected resume: %t, %v", paused, err)
+	}
+	if _, err := sem.RecursiveLockWithOptions("b", LockOptions{Now: now}); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,105 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// watchUpdate holds a watch response for the semaphore of a single manager.
+type watchUpdate struct {
+	index  int
+	resp   clientv3.WatchResponse
+	closed bool
+}
+
+// Watch calls `fn` with the current semaphore of each of `managers`, then with
+// every semaphore update as it is written to etcd, until `ctx` is done or `fn`
+// returns an error. All managers must share the same etcd client.
+//
+// Initial semaphores are read in a single transaction, and watched from the
+// following revision, so that no update is missed.
+func Watch(ctx context.Context, managers []*Manager, fn func(group string, sem *Semaphore) error) error {
+	if len(managers) == 0 {
+		return errors.New("no semaphore to watch")
+	}
+	client := managers[0].client
+
+	ops := make([]clientv3.Op, 0, len(managers))
+	for _, m := range managers {
+		if m == nil {
+			return ErrNilManager
+		}
+		ops = append(ops, clientv3.OpGet(m.keyPath))
+	}
+	resp, err := client.Txn(ctx).Then(ops...).Commit()
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrUnavailable, err)
+	}
+	for i, m := range managers {
+		kvs := resp.Responses[i].GetResponseRange().GetKvs()
+		if len(kvs) != 1 {
+			return fmt.Errorf("%w: group %q: unexpected number of results: %d", ErrCorrupt, m.group, len(kvs))
+		}
+		sem, err := decodeSemaphore(kvs[0].Value)
+		if err != nil {
+			return err
+		}
+		if err := fn(m.group, sem); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(clientv3.WithRequireLeader(ctx))
+	defer cancel()
+	updates := make(chan watchUpdate)
+	for i, m := range managers {
+		watchCh := client.Watch(ctx, m.keyPath, clientv3.WithRev(resp.Header.Revision+1))
+		go forwardWatch(ctx, i, watchCh, updates)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case update := <-updates:
+			group := managers[update.index].group
+			if update.closed {
+				return fmt.Errorf("%w: watch on group %q closed", ErrUnavailable, group)
+			}
+			if err := update.resp.Err(); err != nil {
+				return fmt.Errorf("%w: %s", ErrUnavailable, err)
+			}
+			for _, ev := range update.resp.Events {
+				if ev.Type != clientv3.EventTypePut {
+					continue
+				}
+				sem, err := decodeSemaphore(ev.Kv.Value)
+				if err != nil {
+					return fmt.Errorf("group %q: %w", group, err)
+				}
+				if err := fn(group, sem); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// forwardWatch forwards watch responses for the manager at `index` to
+// `updates`, until the watch is closed or `ctx` is done.
+func forwardWatch(ctx context.Context, index int, watchCh clientv3.WatchChan, updates chan<- watchUpdate) {
+	for resp := range watchCh {
+		select {
+		case updates <- watchUpdate{index: index, resp: resp}:
+		case <-ctx.Done():
+			return
+		}
+	}
+	select {
+	case updates <- watchUpdate{index: index, closed: true}:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,392 @@
+package maintenance
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Blackout is a period during which no new lock is granted.
+type Blackout struct {
+	// Name is the blackout name (the event summary).
+	Name string `json:"name"`
+	// Start is the blackout start (inclusive).
+	Start time.Time `json:"start"`
+	// End is the blackout end (exclusive).
+	End time.Time `json:"end"`
+}
+
+// Calendar holds blackouts loaded from an iCalendar file, reloading it
+// whenever it is modified on disk.
+type Calendar struct {
+	path string
+
+	mu        sync.Mutex
+	modTime   time.Time
+	blackouts []Blackout
+}
+
+// NewCalendar returns a calendar with all events of the iCalendar file at `path`.
+func NewCalendar(path string) (*Calendar, error) {
+	calendar := Calendar{path: path}
+	if _, err := calendar.reloadIfChanged(); err != nil {
+		return nil, err
+	}
+
+	return &calendar, nil
+}
+
+// Active returns the blackout active at time `now` (ending last, if several
+// overlap), or nil. The file is reloaded first if it changed.
+func (c *Calendar) Active(now time.Time) *Blackout {
+	if c == nil {
+		return nil
+	}
+
+	changed, err := c.reloadIfChanged()
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"calendar": c.path,
+			"reason":   err.Error(),
+		}).Warn("blackout calendar reload failed, keeping previous events")
+	} else if changed {
+		logrus.WithFields(logrus.Fields{
+			"calendar": c.path,
+		}).Info("blackout calendar reloaded")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var active *Blackout
+	for i, blackout := range c.blackouts {
+		if now.Before(blackout.Start) || !now.Before(blackout.End) {
+			continue
+		}
+		if active == nil || blackout.End.After(active.End) {
+			active = &c.blackouts[i]
+		}
+	}
+	if active == nil {
+		return nil
+	}
+	result := *active
+	return &result
+}
+
+// reloadIfChanged (re-)loads the file if its modification time changed,
+// returning whether a reload happened.
+func (c *Calendar) reloadIfChanged() (bool, error) {
+	info, err := os.Stat(c.path)
+	if err != nil {
+		return false, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.modTime.IsZero() && info.ModTime().Equal(c.modTime) {
+		return false, nil
+	}
+
+	file, err := os.Open(c.path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	blackouts, err := ParseICalendar(file)
+	if err != nil {
+		return false, fmt.Errorf("invalid calendar %q: %w", c.path, err)
+	}
+	c.blackouts = blackouts
+	c.modTime = info.ModTime()
+
+	return true, nil
+}
+
+// ParseICalendar parses all events of an iCalendar (RFC 5545) stream as blackouts.
+//
+// Only single-occurrence events are supported: events with recurrences (RRULE,
+// RDATE or EXDATE) are skipped with a warning, so that recurring blackouts are not
+// silently reduced to their first occurrence. Cancelled events are ignored. Times
+// without time zone are in local time, as are times in unknown time zones (with a
+// warning) which are neither in the time zone database nor defined in the stream.
+func ParseICalendar(r io.Reader) ([]Blackout, error) {
+	lines, err := unfoldLines(r)
+	if err != nil {
+		return nil, err
+	}
+	contents := make([]contentLine, len(lines))
+	for i, line := range lines {
+		contents[i], err = parseContentLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+	}
+	zones := zoneResolver{
+		defined: parseTimeZones(contents),
+		unknown: map[string]bool{},
+	}
+
+	blackouts := []Blackout{}
+	var event map[string]contentLine
+	// nested is the depth of components (e.g. alarms) within the current event.
+	nested := 0
+	for i, content := range contents {
+		switch {
+		case content.name == "BEGIN" && strings.EqualFold(content.value, "VEVENT"):
+			event = map[string]contentLine{}
+		case content.name == "END" && strings.EqualFold(content.value, "VEVENT"):
+			if event == nil {
+				return nil, fmt.Errorf("line %d: unexpected end of event", i+1)
+			}
+			blackout, ok, err := eventBlackout(event, &zones)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+			if ok {
+				blackouts = append(blackouts, blackout)
+			}
+			event = nil
+		case event != nil && content.name == "BEGIN":
+			nested++
+		case event != nil && content.name == "END":
+			nested--
+		case event != nil && nested == 0:
+			if _, ok := event[content.name]; !ok {
+				event[content.name] = content
+			}
+		}
+	}
+	if event != nil {
+		return nil, errors.New("unterminated event")
+	}
+
+	return blackouts, nil
+}
+
+// zoneResolver resolves TZID parameters into time zones.
+type zoneResolver struct {
+	// defined holds the time zones defined in the stream, by TZID.
+	defined map[string]timeZone
+	// unknown holds the unknown TZIDs, which have already been warned about.
+	unknown map[string]bool
+}
+
+// in returns the time with wall clock `wall` (of any location) in time zone `tzid`.
+func (r *zoneResolver) in(tzid string, wall time.Time) time.Time {
+	if location, err := time.LoadLocation(tzid); err == nil {
+		return time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), wall.Nanosecond(), location)
+	}
+	if zone, ok := r.defined[tzid]; ok {
+		return zone.in(wall)
+	}
+
+	if !r.unknown[tzid] {
+		r.unknown[tzid] = true
+		logrus.WithFields(logrus.Fields{
+			"tzid": tzid,
+		}).Warn("unknown time zone in calendar, using local time")
+	}
+	return time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), wall.Nanosecond(), time.Local)
+}
+
+// contentLine is a parsed iCalendar content line.
+type contentLine struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// unfoldLines reads all lines, joining folded continuation lines.
+func unfoldLines(r io.Reader) ([]string, error) {
+	lines := []string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line == "" {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return lines, nil
+}
+
+// parseContentLine parses a `NAME;PARAM=VALUE:VALUE` line.
+func parseContentLine(line string) (contentLine, error) {
+	quoted := false
+	sep := -1
+	for i, r := range line {
+		if r == '"' {
+			quoted = !quoted
+		}
+		if r == ':' && !quoted {
+			sep = i
+			break
+		}
+	}
+	if sep < 0 {
+		return contentLine{}, fmt.Errorf("invalid content line %q", line)
+	}
+
+	parts := strings.Split(line[:sep], ";")
+	content := contentLine{
+		name:   strings.ToUpper(parts[0]),
+		params: map[string]string{},
+		value:  line[sep+1:],
+	}
+	for _, param := range parts[1:] {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		content.params[strings.ToUpper(kv[0])] = strings.Trim(kv[1], `"`)
+	}
+
+	return content, nil
+}
+
+// eventBlackout converts event properties into a blackout, returning whether
+// the event is relevant.
+func eventBlackout(event map[string]contentLine, zones *zoneResolver) (Blackout, bool, error) {
+	if status, ok := event["STATUS"]; ok && strings.EqualFold(status.value, "CANCELLED") {
+		return Blackout{}, false, nil
+	}
+	name := unescapeText(event["SUMMARY"].value)
+	if name == "" {
+		name = "unnamed blackout"
+	}
+	for _, property := range []string{"RRULE", "RDATE", "EXDATE"} {
+		if _, ok := event[property]; ok {
+			logrus.WithFields(logrus.Fields{
+				"event":    name,
+				"property": property,
+			}).Warn("skipping recurring calendar event, list each occurrence as an event instead")
+			return Blackout{}, false, nil
+		}
+	}
+
+	dtstart, ok := event["DTSTART"]
+	if !ok {
+		return Blackout{}, false, errors.New("event without DTSTART")
+	}
+	start, allDay, err := parseDateTime(dtstart, zones)
+	if err != nil {
+		return Blackout{}, false, fmt.Errorf("invalid DTSTART: %w", err)
+	}
+
+	end := start
+	if allDay {
+		end = start.AddDate(0, 0, 1)
+	}
+	if dtend, ok := event["DTEND"]; ok {
+		end, _, err = parseDateTime(dtend, zones)
+		if err != nil {
+			return Blackout{}, false, fmt.Errorf("invalid DTEND: %w", err)
+		}
+	} else if duration, ok := event["DURATION"]; ok {
+		d, err := parseDuration(duration.value)
+		if err != nil {
+			return Blackout{}, false, fmt.Errorf("invalid DURATION: %w", err)
+		}
+		end = start.Add(d)
+	}
+
+	blackout := Blackout{
+		Name:  name,
+		Start: start,
+		End:   end,
+	}
+	return blackout, end.After(start), nil
+}
+
+// parseDateTime parses a DATE or DATE-TIME property, returning whether it is a date.
+func parseDateTime(content contentLine, zones *zoneResolver) (time.Time, bool, error) {
+	value := content.value
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse("20060102T150405Z", value)
+		return t, false, err
+	}
+
+	layout := "20060102T150405"
+	allDay := content.params["VALUE"] == "DATE" || len(value) == 8
+	if allDay {
+		layout = "20060102"
+	}
+	t, err := time.ParseInLocation(layout, value, time.Local)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	if tzid, ok := content.params["TZID"]; ok {
+		t = zones.in(tzid, t)
+	}
+	return t, allDay, nil
+}
+
+// parseDuration parses an iCalendar duration (e.g. `P1D`, `PT2H30M`, `P1W`).
+func parseDuration(value string) (time.Duration, error) {
+	if !strings.HasPrefix(value, "P") {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+
+	var total time.Duration
+	inTime := false
+	number := ""
+	for _, r := range value[1:] {
+		switch {
+		case r >= '0' && r <= '9':
+			number += string(r)
+			continue
+		case r == 'T':
+			inTime = true
+			continue
+		}
+		n, err := strconv.Atoi(number)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		number = ""
+		switch {
+		case r == 'W' && !inTime:
+			total += time.Duration(n) * 7 * 24 * time.Hour
+		case r == 'D' && !inTime:
+			total += time.Duration(n) * 24 * time.Hour
+		case r == 'H' && inTime:
+			total += time.Duration(n) * time.Hour
+		case r == 'M' && inTime:
+			total += time.Duration(n) * time.Minute
+		case r == 'S' && inTime:
+			total += time.Duration(n) * time.Second
+		default:
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+	}
+	if number != "" {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+
+	return total, nil
+}
+
+// unescapeText unescapes an iCalendar TEXT value.
+func unescapeText(value string) string {
+	replacer := strings.NewReplacer(`\\`, `\`, `\;`, `;`, `\,`, `,`, `\n`, " ", `\N`, " ")
+	return replacer.Replace(value)
+}
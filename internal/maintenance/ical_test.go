@@ -0,0 +1,203 @@
+package maintenance
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testCalendar = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"PRODID:-//Example//Change Management//EN\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:1@example.com\r\n" +
+	"SUMMARY:Holiday freeze\\, all regions\r\n" +
+	"DTSTART;VALUE=DATE:20211224\r\n" +
+	"DTEND;VALUE=DATE:20211227\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:2@example.com\r\n" +
+	"SUMMARY:Product launch with a rather long name that is folded over \r\n" +
+	" two lines\r\n" +
+	"DTSTART;TZID=Europe/Berlin:20211201T090000\r\n" +
+	"DURATION:PT8H\r\n" +
+	"BEGIN:VALARM\r\n" +
+	"TRIGGER:-PT15M\r\n" +
+	"DURATION:PT5M\r\n" +
+	"END:VALARM\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:3@example.com\r\n" +
+	"SUMMARY:Cancelled migration\r\n" +
+	"STATUS:CANCELLED\r\n" +
+	"DTSTART:20211202T100000Z\r\n" +
+	"DTEND:20211202T120000Z\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestParseICalendar(t *testing.T) {
+	blackouts, err := ParseICalendar(strings.NewReader(testCalendar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blackouts) != 2 {
+		t.Fatalf("expected 2 blackouts, got %d", len(blackouts))
+	}
+
+	holiday := blackouts[0]
+	if holiday.Name != "Holiday freeze, all regions" {
+		t.Errorf("unexpected name: %q", holiday.Name)
+	}
+	if holiday.End.Sub(holiday.Start) != 72*time.Hour {
+		t.Errorf("unexpected bounds: %s - %s", holiday.Start, holiday.End)
+	}
+
+	launch := blackouts[1]
+	if launch.Name != "Product launch with a rather long name that is folded over two lines" {
+		t.Errorf("unexpected name: %q", launch.Name)
+	}
+	expectedStart := time.Date(2021, time.December, 1, 8, 0, 0, 0, time.UTC)
+	if !launch.Start.Equal(expectedStart) || !launch.End.Equal(expectedStart.Add(8*time.Hour)) {
+		t.Errorf("unexpected bounds: %s - %s", launch.Start, launch.End)
+	}
+
+	invalid := []string{
+		"BEGIN:VEVENT\r\nSUMMARY:no start\r\nEND:VEVENT\r\n",
+		"BEGIN:VEVENT\r\nDTSTART:20211301T000000Z\r\nEND:VEVENT\r\n",
+		"BEGIN:VEVENT\r\nDTSTART:20211201T000000Z\r\nDURATION:1H\r\nEND:VEVENT\r\n",
+		"BEGIN:VEVENT\r\nDTSTART:20211201T000000Z\r\n",
+		"no content line\r\n",
+	}
+	for _, input := range invalid {
+		if _, err := ParseICalendar(strings.NewReader(input)); err == nil {
+			t.Errorf("expected error for %q", input)
+		}
+	}
+}
+
+func TestParseICalendarRecurrences(t *testing.T) {
+	recurring := []string{
+		"RRULE:FREQ=WEEKLY;BYDAY=FR",
+		"RDATE:20211210T000000Z",
+		"EXDATE:20211210T000000Z",
+	}
+	for _, property := range recurring {
+		input := "BEGIN:VCALENDAR\r\n" +
+			"BEGIN:VEVENT\r\n" +
+			"SUMMARY:Weekly freeze\r\n" +
+			"DTSTART:20211203T000000Z\r\n" +
+			"DTEND:20211204T000000Z\r\n" +
+			property + "\r\n" +
+			"END:VEVENT\r\n" +
+			"BEGIN:VEVENT\r\n" +
+			"SUMMARY:Launch\r\n" +
+			"DTSTART:20211206T000000Z\r\n" +
+			"DTEND:20211207T000000Z\r\n" +
+			"END:VEVENT\r\n" +
+			"END:VCALENDAR\r\n"
+		// Recurring events are skipped, without failing the whole calendar.
+		blackouts, err := ParseICalendar(strings.NewReader(input))
+		if err != nil {
+			t.Errorf("unexpected error for %q: %s", property, err)
+			continue
+		}
+		if len(blackouts) != 1 || blackouts[0].Name != "Launch" {
+			t.Errorf("unexpected blackouts for %q: %v", property, blackouts)
+		}
+	}
+}
+
+func TestParseICalendarTimeZones(t *testing.T) {
+	// As exported by Outlook, with a Windows time zone name.
+	input := "BEGIN:VCALENDAR\r\n" +
+		"BEGIN:VTIMEZONE\r\n" +
+		"TZID:W. Europe Standard Time\r\n" +
+		"BEGIN:STANDARD\r\n" +
+		"DTSTART:16010101T030000\r\n" +
+		"TZOFFSETFROM:+0200\r\n" +
+		"TZOFFSETTO:+0100\r\n" +
+		"RRULE:FREQ=YEARLY;BYDAY=-1SU;BYMONTH=10\r\n" +
+		"END:STANDARD\r\n" +
+		"BEGIN:DAYLIGHT\r\n" +
+		"DTSTART:16010101T020000\r\n" +
+		"TZOFFSETFROM:+0100\r\n" +
+		"TZOFFSETTO:+0200\r\n" +
+		"RRULE:FREQ=YEARLY;BYDAY=-1SU;BYMONTH=3\r\n" +
+		"END:DAYLIGHT\r\n" +
+		"END:VTIMEZONE\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"SUMMARY:Summer\r\n" +
+		"DTSTART;TZID=W. Europe Standard Time:20210701T090000\r\n" +
+		"DURATION:PT1H\r\n" +
+		"END:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"SUMMARY:Winter\r\n" +
+		"DTSTART;TZID=W. Europe Standard Time:20211201T090000\r\n" +
+		"DURATION:PT1H\r\n" +
+		"END:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"SUMMARY:Before switching to daylight time\r\n" +
+		"DTSTART;TZID=W. Europe Standard Time:20210328T013000\r\n" +
+		"DURATION:PT1H\r\n" +
+		"END:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"SUMMARY:Unknown\r\n" +
+		"DTSTART;TZID=Olympus Mons Time:20211201T090000\r\n" +
+		"DURATION:PT1H\r\n" +
+		"END:VEVENT\r\n" +
+		"END:VCALENDAR\r\n"
+
+	blackouts, err := ParseICalendar(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []time.Time{
+		time.Date(2021, time.July, 1, 7, 0, 0, 0, time.UTC),
+		time.Date(2021, time.December, 1, 8, 0, 0, 0, time.UTC),
+		time.Date(2021, time.March, 28, 0, 30, 0, 0, time.UTC),
+		// Unknown time zones fall back to local time.
+		time.Date(2021, time.December, 1, 9, 0, 0, 0, time.Local),
+	}
+	if len(blackouts) != len(expected) {
+		t.Fatalf("expected %d blackouts, got %d", len(expected), len(blackouts))
+	}
+	for i, blackout := range blackouts {
+		if !blackout.Start.Equal(expected[i]) {
+			t.Errorf("%s: expected start %s, got %s", blackout.Name, expected[i], blackout.Start)
+		}
+	}
+}
+
+func TestCalendarActive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "freeze.ics")
+	if err := os.WriteFile(path, []byte(testCalendar), 0644); err != nil {
+		t.Fatal(err)
+	}
+	calendar, err := NewCalendar(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	during := time.Date(2021, time.December, 1, 12, 0, 0, 0, time.UTC)
+	if blackout := calendar.Active(during); blackout == nil || !strings.HasPrefix(blackout.Name, "Product launch") {
+		t.Errorf("unexpected blackout: %v", blackout)
+	}
+	if blackout := calendar.Active(during.Add(24 * time.Hour)); blackout != nil {
+		t.Errorf("unexpected blackout: %v", blackout)
+	}
+
+	// Changes are picked up on the next lookup.
+	updated := strings.Replace(testCalendar, "DURATION:PT8H", "DURATION:P2D", 1)
+	if err := os.WriteFile(path, []byte(updated), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if blackout := calendar.Active(during.Add(24 * time.Hour)); blackout == nil {
+		t.Error("expected blackout after reload")
+	}
+}
@@ -0,0 +1,202 @@
+package maintenance
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// icalWeekdays maps iCalendar weekday codes to weekdays.
+var icalWeekdays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+// timeZone is a time zone defined by a VTIMEZONE component, for TZIDs which
+// are not in the time zone database (e.g. Windows names used by Outlook).
+type timeZone struct {
+	id          string
+	observances []observance
+}
+
+// observance is a STANDARD or DAYLIGHT period of a VTIMEZONE component.
+type observance struct {
+	// start is the wall clock time of the first onset.
+	start time.Time
+	// offset is the UTC offset in seconds during the observance.
+	offset int
+	// month, week and weekday define a yearly onset (e.g. the last Sunday of
+	// March for week -1), or a single onset if month is zero.
+	month   time.Month
+	week    int
+	weekday time.Weekday
+}
+
+// in returns the time with wall clock `wall` (of any location) in the zone.
+func (z timeZone) in(wall time.Time) time.Time {
+	naive := time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), wall.Nanosecond(), time.UTC)
+
+	offset := z.observances[0].offset
+	var latest time.Time
+	for _, o := range z.observances {
+		onset, ok := o.lastOnset(naive)
+		if ok && (latest.IsZero() || onset.After(latest)) {
+			latest = onset
+			offset = o.offset
+		}
+	}
+
+	return naive.Add(-time.Duration(offset) * time.Second).In(time.FixedZone(z.id, offset))
+}
+
+// lastOnset returns the latest onset of the observance not after `wall`.
+func (o observance) lastOnset(wall time.Time) (time.Time, bool) {
+	if o.month == 0 {
+		return o.start, !o.start.After(wall)
+	}
+
+	for year := wall.Year(); year >= wall.Year()-1; year-- {
+		onset := nthWeekday(year, o.month, o.week, o.weekday)
+		onset = onset.Add(time.Duration(o.start.Hour())*time.Hour + time.Duration(o.start.Minute())*time.Minute)
+		if !onset.After(wall) && !onset.Before(o.start) {
+			return onset, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// nthWeekday returns the `n`th `weekday` of the month (counting from the end
+// if negative), in UTC.
+func nthWeekday(year int, month time.Month, n int, weekday time.Weekday) time.Time {
+	if n < 0 {
+		last := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC)
+		back := (int(last.Weekday()) - int(weekday) + 7) % 7
+		return last.AddDate(0, 0, -back+(n+1)*7)
+	}
+
+	first := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	ahead := (int(weekday) - int(first.Weekday()) + 7) % 7
+	return first.AddDate(0, 0, ahead+(n-1)*7)
+}
+
+// parseTimeZones returns the time zones defined by VTIMEZONE components, by TZID.
+//
+// Invalid components are skipped with a warning, so that their TZID falls back
+// to local time.
+func parseTimeZones(contents []contentLine) map[string]timeZone {
+	zones := map[string]timeZone{}
+
+	var zone *timeZone
+	var properties map[string]contentLine
+	valid := true
+	for _, content := range contents {
+		switch {
+		case content.name == "BEGIN" && strings.EqualFold(content.value, "VTIMEZONE"):
+			zone = &timeZone{}
+			valid = true
+		case zone == nil:
+			continue
+		case content.name == "END" && strings.EqualFold(content.value, "VTIMEZONE"):
+			if valid && zone.id != "" && len(zone.observances) > 0 {
+				zones[zone.id] = *zone
+			}
+			zone = nil
+		case content.name == "TZID" && properties == nil:
+			zone.id = content.value
+		case content.name == "BEGIN":
+			properties = map[string]contentLine{}
+		case content.name == "END" && properties != nil:
+			o, err := parseObservance(properties)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"tzid":   zone.id,
+					"reason": err.Error(),
+				}).Warn("skipping invalid time zone definition in calendar")
+				valid = false
+			}
+			zone.observances = append(zone.observances, o)
+			properties = nil
+		case properties != nil:
+			properties[content.name] = content
+		}
+	}
+
+	return zones
+}
+
+// parseObservance parses the properties of a STANDARD or DAYLIGHT component.
+func parseObservance(properties map[string]contentLine) (observance, error) {
+	o := observance{}
+
+	start, err := time.Parse("20060102T150405", properties["DTSTART"].value)
+	if err != nil {
+		return o, fmt.Errorf("invalid DTSTART: %w", err)
+	}
+	o.start = start
+	o.offset, err = parseUTCOffset(properties["TZOFFSETTO"].value)
+	if err != nil {
+		return o, fmt.Errorf("invalid TZOFFSETTO: %w", err)
+	}
+
+	rrule, ok := properties["RRULE"]
+	if !ok {
+		return o, nil
+	}
+	rule := map[string]string{}
+	for _, part := range strings.Split(rrule.value, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) == 2 {
+			rule[strings.ToUpper(kv[0])] = strings.ToUpper(kv[1])
+		}
+	}
+	byDay := rule["BYDAY"]
+	if rule["FREQ"] != "YEARLY" || len(byDay) < 3 {
+		return o, fmt.Errorf("unsupported RRULE %q", rrule.value)
+	}
+	month, err := strconv.Atoi(rule["BYMONTH"])
+	if err != nil || month < 1 || month > 12 {
+		return o, fmt.Errorf("unsupported RRULE %q", rrule.value)
+	}
+	week, err := strconv.Atoi(byDay[:len(byDay)-2])
+	weekday, ok := icalWeekdays[byDay[len(byDay)-2:]]
+	if err != nil || !ok || week == 0 || week < -5 || week > 5 {
+		return o, fmt.Errorf("unsupported RRULE %q", rrule.value)
+	}
+	o.month = time.Month(month)
+	o.week = week
+	o.weekday = weekday
+
+	return o, nil
+}
+
+// parseUTCOffset parses a UTC offset (e.g. `+0100` or `-053000`) in seconds.
+func parseUTCOffset(value string) (int, error) {
+	if (len(value) != 5 && len(value) != 7) || (value[0] != '+' && value[0] != '-') {
+		return 0, fmt.Errorf("invalid UTC offset %q", value)
+	}
+
+	seconds := 0
+	for i, unit := range []int{3600, 60, 1} {
+		if 1+2*i >= len(value) {
+			break
+		}
+		n, err := strconv.Atoi(value[1+2*i : 3+2*i])
+		if err != nil {
+			return 0, fmt.Errorf("invalid UTC offset %q", value)
+		}
+		seconds += n * unit
+	}
+	if value[0] == '-' {
+		seconds = -seconds
+	}
+
+	return seconds, nil
+}
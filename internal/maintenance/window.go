@@ -0,0 +1,184 @@
+package maintenance
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	// Embed the time zone database, for hosts without one.
+	_ "time/tzdata"
+)
+
+var (
+	// weekdays maps lowercase day names and abbreviations to weekdays.
+	weekdays = map[string]time.Weekday{
+		"sun": time.Sunday, "sunday": time.Sunday,
+		"mon": time.Monday, "monday": time.Monday,
+		"tue": time.Tuesday, "tuesday": time.Tuesday,
+		"wed": time.Wednesday, "wednesday": time.Wednesday,
+		"thu": time.Thursday, "thursday": time.Thursday,
+		"fri": time.Friday, "friday": time.Friday,
+		"sat": time.Saturday, "saturday": time.Saturday,
+	}
+)
+
+// Window is a recurring weekly time range.
+type Window struct {
+	// Days holds the weekdays on which the window starts (empty means every day).
+	Days []time.Weekday
+	// Start is the window start, as an offset from midnight.
+	Start time.Duration
+	// End is the window end, as an offset from midnight. If not after Start,
+	// the window ends on the following day.
+	End time.Duration
+}
+
+// Schedule is a set of recurring windows in a time zone.
+type Schedule struct {
+	// Location is the time zone of all windows.
+	Location *time.Location
+	// Windows holds all windows, an empty schedule is always open.
+	Windows []Window
+}
+
+// ParseWindow parses a window from day names (e.g. "mon" or "monday") and
+// start/end times of day in "HH:MM" format.
+func ParseWindow(days []string, start string, end string) (Window, error) {
+	window := Window{}
+	for _, day := range days {
+		weekday, ok := weekdays[strings.ToLower(strings.TrimSpace(day))]
+		if !ok {
+			return Window{}, fmt.Errorf("invalid day %q", day)
+		}
+		window.Days = append(window.Days, weekday)
+	}
+
+	var err error
+	window.Start, err = parseTimeOfDay(start)
+	if err != nil {
+		return Window{}, fmt.Errorf("invalid start: %w", err)
+	}
+	window.End, err = parseTimeOfDay(end)
+	if err != nil {
+		return Window{}, fmt.Errorf("invalid end: %w", err)
+	}
+	if window.Start == window.End {
+		return Window{}, errors.New("empty window, start equals end")
+	}
+
+	return window, nil
+}
+
+// NewSchedule returns a schedule for all windows in time zone `timezone`
+// (as in the IANA database, empty means UTC).
+func NewSchedule(timezone string, windows []Window) (*Schedule, error) {
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, err
+	}
+
+	schedule := Schedule{
+		Location: location,
+		Windows:  windows,
+	}
+	return &schedule, nil
+}
+
+// Open returns whether any window is open at time `t`. A nil or empty
+// schedule is always open.
+func (s *Schedule) Open(t time.Time) bool {
+	if s == nil || len(s.Windows) == 0 {
+		return true
+	}
+
+	local := t.In(s.Location)
+	// An open window started today or yesterday (if crossing midnight).
+	for _, daysBack := range []int{0, 1} {
+		for _, window := range s.Windows {
+			start, end := window.occurrence(local, -daysBack)
+			if start != nil && !local.Before(*start) && local.Before(*end) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// NextOpening returns the next time after `t` at which a window opens, or the
+// zero time if the schedule is empty.
+func (s *Schedule) NextOpening(t time.Time) time.Time {
+	if s == nil || len(s.Windows) == 0 {
+		return time.Time{}
+	}
+
+	local := t.In(s.Location)
+	var next time.Time
+	for days := 0; days <= 7; days++ {
+		for _, window := range s.Windows {
+			start, _ := window.occurrence(local, days)
+			if start == nil || !start.After(local) {
+				continue
+			}
+			if next.IsZero() || start.Before(next) {
+				next = *start
+			}
+		}
+		if !next.IsZero() {
+			return next
+		}
+	}
+	return next
+}
+
+// occurrence returns the bounds of the window starting `days` days after
+// the date of `t`, or nil if the window does not start on that day.
+func (w Window) occurrence(t time.Time, days int) (*time.Time, *time.Time) {
+	year, month, day := t.Date()
+	midnight := time.Date(year, month, day+days, 0, 0, 0, 0, t.Location())
+	if !w.startsOn(midnight.Weekday()) {
+		return nil, nil
+	}
+
+	start := addClock(midnight, w.Start)
+	endDay := midnight
+	if w.End <= w.Start {
+		endDay = time.Date(year, month, day+days+1, 0, 0, 0, 0, t.Location())
+	}
+	end := addClock(endDay, w.End)
+	return &start, &end
+}
+
+// startsOn returns whether the window starts on weekday `d`.
+func (w Window) startsOn(d time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, day := range w.Days {
+		if day == d {
+			return true
+		}
+	}
+	return false
+}
+
+// addClock returns the wall-clock time `offset` after midnight of `day`,
+// so that windows follow local time across DST changes.
+func addClock(day time.Time, offset time.Duration) time.Time {
+	year, month, date := day.Date()
+	hours := int(offset / time.Hour)
+	minutes := int((offset % time.Hour) / time.Minute)
+	return time.Date(year, month, date, hours, minutes, 0, 0, day.Location())
+}
+
+// parseTimeOfDay parses a "HH:MM" time of day (up to "24:00") into an offset from midnight.
+func parseTimeOfDay(value string) (time.Duration, error) {
+	var hours, minutes int
+	if _, err := fmt.Sscanf(value, "%d:%d", &hours, &minutes); err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", value)
+	}
+	if hours < 0 || minutes < 0 || minutes > 59 || hours > 24 || (hours == 24 && minutes != 0) {
+		return 0, fmt.Errorf("invalid time of day %q", value)
+	}
+
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute, nil
+}
@@ -0,0 +1,107 @@
+package maintenance
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseWindow(t *testing.T) {
+	window, err := ParseWindow([]string{"mon", "Friday"}, "02:00", "05:30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(window.Days) != 2 || window.Days[0] != time.Monday || window.Days[1] != time.Friday {
+		t.Errorf("unexpected days: %v", window.Days)
+	}
+	if window.Start != 2*time.Hour || window.End != 5*time.Hour+30*time.Minute {
+		t.Errorf("unexpected bounds: %s - %s", window.Start, window.End)
+	}
+
+	invalid := []struct {
+		days  []string
+		start string
+		end   string
+	}{
+		{[]string{"someday"}, "02:00", "05:00"},
+		{nil, "2am", "05:00"},
+		{nil, "02:00", "25:00"},
+		{nil, "02:60", "05:00"},
+		{nil, "02:00", "02:00"},
+	}
+	for _, tt := range invalid {
+		if _, err := ParseWindow(tt.days, tt.start, tt.end); err == nil {
+			t.Errorf("expected error for %v %s-%s", tt.days, tt.start, tt.end)
+		}
+	}
+}
+
+func TestScheduleOpen(t *testing.T) {
+	weekdays, err := ParseWindow([]string{"mon", "tue", "wed", "thu", "fri"}, "02:00", "05:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	overnight, err := ParseWindow([]string{"sat"}, "22:00", "01:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	schedule, err := NewSchedule("Europe/Berlin", []Window{weekdays, overnight})
+	if err != nil {
+		t.Fatal(err)
+	}
+	berlin := schedule.Location
+
+	tests := []struct {
+		at   time.Time
+		open bool
+	}{
+		// Monday, March 1st 2021.
+		{time.Date(2021, time.March, 1, 1, 59, 0, 0, berlin), false},
+		{time.Date(2021, time.March, 1, 2, 0, 0, 0, berlin), true},
+		{time.Date(2021, time.March, 1, 4, 59, 0, 0, berlin), true},
+		{time.Date(2021, time.March, 1, 5, 0, 0, 0, berlin), false},
+		// Same instant, expressed in UTC.
+		{time.Date(2021, time.March, 1, 1, 30, 0, 0, time.UTC), true},
+		// Saturday night, crossing midnight into Sunday.
+		{time.Date(2021, time.March, 6, 3, 0, 0, 0, berlin), false},
+		{time.Date(2021, time.March, 6, 23, 0, 0, 0, berlin), true},
+		{time.Date(2021, time.March, 7, 0, 30, 0, 0, berlin), true},
+		{time.Date(2021, time.March, 7, 3, 0, 0, 0, berlin), false},
+	}
+	for _, tt := range tests {
+		if open := schedule.Open(tt.at); open != tt.open {
+			t.Errorf("%s: expected open=%t", tt.at, tt.open)
+		}
+	}
+
+	var empty *Schedule
+	if !empty.Open(time.Now()) {
+		t.Error("empty schedule should always be open")
+	}
+}
+
+func TestScheduleNextOpening(t *testing.T) {
+	window, err := ParseWindow([]string{"mon", "tue", "wed", "thu", "fri"}, "02:00", "05:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	schedule, err := NewSchedule("UTC", []Window{window})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		at       time.Time
+		expected time.Time
+	}{
+		// Monday before and after the window.
+		{time.Date(2021, time.March, 1, 1, 0, 0, 0, time.UTC), time.Date(2021, time.March, 1, 2, 0, 0, 0, time.UTC)},
+		{time.Date(2021, time.March, 1, 6, 0, 0, 0, time.UTC), time.Date(2021, time.March, 2, 2, 0, 0, 0, time.UTC)},
+		// Friday after the window, skipping the weekend.
+		{time.Date(2021, time.March, 5, 6, 0, 0, 0, time.UTC), time.Date(2021, time.March, 8, 2, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		if next := schedule.NextOpening(tt.at); !next.Equal(tt.expected) {
+			t.Errorf("%s: expected %s, got %s", tt.at, tt.expected, next)
+		}
+	}
+}
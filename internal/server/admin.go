@@ -0,0 +1,282 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/coreos/airlock/internal/config"
+	"github.com/coreos/airlock/internal/herrors"
+	"github.com/coreos/airlock/internal/lock"
+)
+
+const (
+	// AdminEndpoint is the root endpoint of the administrative API.
+	AdminEndpoint = "/v1/admin/groups"
+)
+
+// AdminGroup contains the state of a single group, as listed by the admin API.
+type AdminGroup struct {
+	Group     string          `json:"group"`
+	Semaphore *lock.Semaphore `json:"semaphore"`
+}
+
+// AdminGroups contains the state of all groups, as listed by the admin API.
+type AdminGroups struct {
+	Groups []AdminGroup `json:"groups"`
+}
+
+// adminRequest is the body of an admin API mutation.
+type adminRequest struct {
+	// ID is the node to release (`release`).
+	ID string `json:"id"`
+	// Slots is the new number of slots (`resize`).
+	Slots *uint64 `json:"slots"`
+	// Reason is the reason for the pause (`pause`).
+	Reason string `json:"reason"`
+	// DurationSecs is the pause duration in seconds, zero meaning no expiry (`pause`).
+	DurationSecs uint64 `json:"duration_secs"`
+}
+
+// Admin is the handler for the administrative API, under `/v1/admin/groups`:
+//
+//   - `GET /v1/admin/groups` lists all groups with their semaphore
+//   - `GET /v1/admin/groups/<group>` returns the group semaphore
+//   - `POST /v1/admin/groups/<group>/release` force-releases a holder
+//   - `POST /v1/admin/groups/<group>/resize` changes the number of slots
+//   - `POST /v1/admin/groups/<group>/pause` and `.../resume` pause and resume the group
+//
+// Mutations return the resulting semaphore.
+func (a *Airlock) Admin() http.Handler {
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt64(&a.inFlight, 1)
+		defer atomic.AddInt64(&a.inFlight, -1)
+
+		result, herr := a.adminHandler(req)
+		if herr != nil {
+			http.Error(w, herr.ToJSON(), herr.Code)
+			return
+		}
+
+		out, err := json.Marshal(result)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(out)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+// adminHandler contains admin API handling logic
+func (a *Airlock) adminHandler(req *http.Request) (interface{}, *herrors.HTTPError) {
+	if a == nil {
+		return nil, &errNilAirlockServer
+	}
+	settings := a.currentSettings()
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxBodySize))
+	if err != nil {
+		herr := herrors.New(400, "invalid_request", fmt.Sprintf("failed to read request body: %s", err.Error()))
+		return nil, &herr
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	if herr := authenticateAdmin(req, settings); herr != nil {
+		return nil, herr
+	}
+
+	group, action, herr := parseAdminPath(req.URL.Path)
+	if herr != nil {
+		return nil, herr
+	}
+	method := http.MethodPost
+	if action == "" {
+		method = http.MethodGet
+	}
+	if req.Method != method {
+		herr := herrors.New(405, "method_not_allowed", fmt.Sprintf("method %s not allowed, expected %s", req.Method, method))
+		return nil, &herr
+	}
+	groupSettings, ok := settings.LockGroups[group]
+	if group != "" && !ok {
+		herr := herrors.New(404, "unknown_group", fmt.Sprintf("unknown group %q", group))
+		return nil, &herr
+	}
+	request := adminRequest{}
+	if len(body) > 0 && action != "" {
+		if err := json.Unmarshal(body, &request); err != nil {
+			herr := herrors.New(400, "invalid_request", fmt.Sprintf("failed to parse request body: %s", err.Error()))
+			return nil, &herr
+		}
+	}
+	if herr := validateAdminRequest(action, groupSettings, settings.ReconcileSlots, request); herr != nil {
+		return nil, herr
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), settings.EtcdTxnTimeout)
+	defer cancel()
+
+	if group == "" {
+		return a.adminListGroups(ctx, settings)
+	}
+	manager, err := a.groupManager(ctx, group)
+	if err != nil {
+		herr := lockHTTPError(err, "failed_sem_init")
+		return nil, &herr
+	}
+
+	var semaphore *lock.Semaphore
+	switch action {
+	case "":
+		semaphore, err = manager.FetchSemaphore(ctx)
+	case "release":
+		semaphore, err = a.adminRelease(ctx, settings, group, request.ID)
+	case "resize":
+		semaphore, _, err = manager.ReconcileSlots(ctx, *request.Slots)
+	case "pause":
+		now := time.Now()
+		var until time.Time
+		if request.DurationSecs > 0 {
+			until = now.Add(time.Duration(request.DurationSecs) * time.Second)
+		}
+		semaphore, err = manager.Pause(ctx, now, until, request.Reason)
+	case "resume":
+		semaphore, _, err = manager.Resume(ctx)
+	}
+	if err != nil {
+		herr := lockHTTPError(err, "failed_admin_action")
+		return nil, &herr
+	}
+
+	if action != "" {
+		logrus.WithFields(logrus.Fields{
+			"action": action,
+			"group":  group,
+			"id":     request.ID,
+			"remote": req.RemoteAddr,
+		}).Warn("admin action applied")
+		updateSemaphoreMetrics(settings, group, semaphore)
+	}
+
+	return semaphore, nil
+}
+
+// adminListGroups returns the semaphores of all groups.
+func (a *Airlock) adminListGroups(ctx context.Context, settings config.Settings) (interface{}, *herrors.HTTPError) {
+	groups := AdminGroups{Groups: []AdminGroup{}}
+	for _, group := range sortedGroups(settings) {
+		manager, err := a.groupManager(ctx, group)
+		if err != nil {
+			herr := lockHTTPError(err, "failed_sem_init")
+			return nil, &herr
+		}
+		semaphore, err := manager.FetchSemaphore(ctx)
+		if err != nil {
+			herr := lockHTTPError(err, "failed_sem_fetch")
+			return nil, &herr
+		}
+		groups.Groups = append(groups.Groups, AdminGroup{Group: group, Semaphore: semaphore})
+	}
+
+	return groups, nil
+}
+
+// adminRelease force-releases holder `id` from `group`, and from all other
+// groups it locked together with it (as on a steady-state report).
+func (a *Airlock) adminRelease(ctx context.Context, settings config.Settings, group string, id string) (*lock.Semaphore, error) {
+	groups := settings.GroupsFor(id, group)
+	managers, err := a.groupManagers(ctx, groups)
+	if err != nil {
+		return nil, err
+	}
+	sems, released, err := lock.UnlockAll(ctx, managers, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if released != nil {
+		observeHoldDuration(group, *released, time.Now())
+	}
+	for i, sem := range sems {
+		updateSemaphoreMetrics(settings, groups[i], sem)
+	}
+	return sems[0], nil
+}
+
+// validateAdminRequest checks the request body of an admin `action`. In
+// particular, groups can only be resized if the consistency checker does not
+// revert it.
+func validateAdminRequest(action string, groupSettings config.GroupSettings, reconcile bool, request adminRequest) *herrors.HTTPError {
+	switch action {
+	case "release":
+		if request.ID == "" {
+			herr := herrors.New(400, "invalid_request", "missing id")
+			return &herr
+		}
+	case "resize":
+		if request.Slots == nil {
+			herr := herrors.New(400, "invalid_request", "missing slots")
+			return &herr
+		}
+		if groupSettings.SlotsPercent > 0 || reconcile {
+			herr := herrors.New(409, "managed_slots", "group slots are reconciled with configuration, edit it instead")
+			return &herr
+		}
+	}
+
+	return nil
+}
+
+// authenticateAdmin checks that the request carries the admin token, if configured.
+//
+// Without a token, clients are authenticated by their TLS certificate only.
+func authenticateAdmin(req *http.Request, settings config.Settings) *herrors.HTTPError {
+	if len(settings.AdminToken) == 0 {
+		return nil
+	}
+	if err := verifySecret(req, settings.AdminToken, time.Now()); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"reason": err.Error(),
+			"remote": req.RemoteAddr,
+		}).Warn("admin authentication failed")
+		herr := herrors.New(401, "unauthenticated_admin", fmt.Sprintf("admin authentication failed: %s", err.Error()))
+		return &herr
+	}
+
+	return nil
+}
+
+// parseAdminPath splits an admin API path into group and action.
+func parseAdminPath(path string) (string, string, *herrors.HTTPError) {
+	rest := strings.TrimPrefix(path, AdminEndpoint)
+	rest = strings.TrimSuffix(strings.TrimPrefix(rest, "/"), "/")
+	if rest == "" {
+		return "", "", nil
+	}
+
+	parts := strings.Split(rest, "/")
+	if len(parts) > 2 || parts[0] == "" || (len(parts) == 2 && parts[1] == "") {
+		herr := herrors.New(404, "not_found", fmt.Sprintf("unknown admin endpoint %q", path))
+		return "", "", &herr
+	}
+	if len(parts) == 1 {
+		return parts[0], "", nil
+	}
+	switch parts[1] {
+	case "release", "resize", "pause", "resume":
+		return parts[0], parts[1], nil
+	default:
+		herr := herrors.New(404, "unknown_action", fmt.Sprintf("unknown admin action %q", parts[1]))
+		return "", "", &herr
+	}
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/coreos/airlock/internal/config"
+)
+
+func TestParseAdminPath(t *testing.T) {
+	tests := []struct {
+		path   string
+		group  string
+		action string
+		code   int
+	}{
+		{"/v1/admin/groups", "", "", 0},
+		{"/v1/admin/groups/", "", "", 0},
+		{"/v1/admin/groups/workers", "workers", "", 0},
+		{"/v1/admin/groups/workers/release", "workers", "release", 0},
+		{"/v1/admin/groups/workers/resume/", "workers", "resume", 0},
+		{"/v1/admin/groups/workers/destroy", "", "", 404},
+		{"/v1/admin/groups/workers/pause/now", "", "", 404},
+		{"/v1/admin/groups//pause", "", "", 404},
+	}
+	for _, tt := range tests {
+		group, action, herr := parseAdminPath(tt.path)
+		if tt.code != 0 {
+			if herr == nil || herr.Code != tt.code {
+				t.Errorf("path %q: expected error %d, got %v", tt.path, tt.code, herr)
+			}
+			continue
+		}
+		if herr != nil {
+			t.Errorf("path %q: unexpected error: %v", tt.path, herr)
+			continue
+		}
+		if group != tt.group || action != tt.action {
+			t.Errorf("path %q: expected (%q, %q), got (%q, %q)", tt.path, tt.group, tt.action, group, action)
+		}
+	}
+}
+
+func TestAuthenticateAdmin(t *testing.T) {
+	settings := config.Settings{AdminToken: config.Secret("s3cret")}
+
+	tests := []struct {
+		auth  string
+		valid bool
+	}{
+		{"Bearer s3cret", true},
+		{"Bearer wrong", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", AdminEndpoint, nil)
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		herr := authenticateAdmin(req, settings)
+		if tt.valid && herr != nil {
+			t.Errorf("auth %q: unexpected error: %v", tt.auth, herr)
+		}
+		if !tt.valid && (herr == nil || herr.Code != 401) {
+			t.Errorf("auth %q: expected 401, got %v", tt.auth, herr)
+		}
+	}
+
+	// Without a token, clients are authenticated by TLS only.
+	req := httptest.NewRequest("GET", AdminEndpoint, nil)
+	if herr := authenticateAdmin(req, config.Settings{}); herr != nil {
+		t.Errorf("unexpected error without token: %v", herr)
+	}
+}
+
+func TestValidateAdminRequest(t *testing.T) {
+	slots := uint64(3)
+	fixed := config.GroupSettings{Slots: 1}
+	percent := config.GroupSettings{SlotsPercent: 50}
+
+	tests := []struct {
+		action        string
+		groupSettings config.GroupSettings
+		reconcile     bool
+		request       adminRequest
+		code          int
+	}{
+		{"release", fixed, false, adminRequest{ID: "a"}, 0},
+		{"release", fixed, false, adminRequest{}, 400},
+		{"resize", fixed, false, adminRequest{Slots: &slots}, 0},
+		{"resize", fixed, false, adminRequest{}, 400},
+		{"resize", fixed, true, adminRequest{Slots: &slots}, 409},
+		{"resize", percent, false, adminRequest{Slots: &slots}, 409},
+		{"pause", fixed, false, adminRequest{}, 0},
+		{"", fixed, false, adminRequest{}, 0},
+	}
+	for _, tt := range tests {
+		herr := validateAdminRequest(tt.action, tt.groupSettings, tt.reconcile, tt.request)
+		if tt.code == 0 && herr != nil {
+			t.Errorf("%s %+v: unexpected error: %v", tt.action, tt.request, herr)
+		}
+		if tt.code != 0 && (herr == nil || herr.Code != tt.code) {
+			t.Errorf("%s %+v: expected %d, got %v", tt.action, tt.request, tt.code, herr)
+		}
+	}
+}
@@ -3,10 +3,15 @@ package server
 import (
 	"context"
 	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
+	clientv3 "go.etcd.io/etcd/client/v3"
 
 	"github.com/coreos/airlock/internal/config"
 	"github.com/coreos/airlock/internal/herrors"
@@ -27,6 +32,16 @@ var (
 		Name: "airlock_config_semaphore_slots",
 		Help: "Total number of configured slots per group.",
 	}, []string{"group"})
+	// configSlotsPercentGauge holds a metrics gauge with per-group configured slots percentage.
+	configSlotsPercentGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "airlock_config_semaphore_slots_percent",
+		Help: "Configured percentage of group members allowed to hold a slot, per percentage-based group.",
+	}, []string{"group"})
+	// groupMembersGauge holds a metrics gauge with per-group known members.
+	groupMembersGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "airlock_group_members",
+		Help: "Total number of known nodes per group (excluding stale members), in the database.",
+	}, []string{"group"})
 	// databaseLocksGauge holds a metrics gauge with per-group lock-holders status.
 	databaseLocksGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "airlock_database_semaphore_lock_holders",
@@ -37,11 +52,137 @@ var (
 		Name: "airlock_database_semaphore_slots",
 		Help: "Total number of slots per group, in the database.",
 	}, []string{"group"})
+	// databaseWeightGauge holds a metrics gauge with per-group slots consumed by holders' weights.
+	databaseWeightGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "airlock_database_semaphore_weight_used",
+		Help: "Total number of slots consumed by lock holders (by weight) per group, in the database.",
+	}, []string{"group"})
+	// databaseWaitersGauge holds a metrics gauge with per-group, per-priority-class waiting nodes.
+	databaseWaitersGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "airlock_database_semaphore_waiters",
+		Help: "Total number of nodes waiting for a slot per group and priority class, in the database.",
+	}, []string{"group", "class"})
+	// pausedGauge holds a metrics gauge with per-group pause status.
+	pausedGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "airlock_group_paused",
+		Help: "Whether each group is paused (1) or not (0), in the database.",
+	}, []string{"group"})
+	// grantsCounter holds a metrics counter with per-group, per-priority-class granted locks.
+	grantsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "airlock_lock_grants_total",
+		Help: "Total number of pre-reboot requests granted a slot, per group and priority class.",
+	}, []string{"group", "class"})
+	// databaseOldestHolderGauge holds a metrics gauge with per-group age of the oldest lock holder.
+	databaseOldestHolderGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "airlock_database_semaphore_oldest_holder_age_seconds",
+		Help: "Time elapsed since the oldest current lock was acquired per group, in the database.",
+	}, []string{"group"})
+	// holdDurationHistogram holds a metrics histogram with per-group lock hold durations.
+	holdDurationHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "airlock_lock_hold_duration_seconds",
+		Help:    "Time elapsed between a lock grant and its release (or expiry), per group.",
+		Buckets: []float64{30, 60, 120, 300, 600, 1200, 1800, 3600, 7200, 14400, 28800},
+	}, []string{"group"})
+	// expiredLocksCounter holds a metrics counter with per-group expired locks.
+	expiredLocksCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "airlock_expired_locks_total",
+		Help: "Total number of locks released due to an overdue lease, per group.",
+	}, []string{"group"})
 )
 
 // Airlock is the main service
 type Airlock struct {
-	config.Settings
+	// inFlight is the number of lock requests currently being served.
+	inFlight int64
+	// client is the etcd client shared by all lock managers.
+	client *clientv3.Client
+
+	// mu protects settings and managers, which are swapped on reload.
+	mu sync.RWMutex
+	// settings is the current runtime configuration.
+	settings config.Settings
+	// managers holds per-group lock managers.
+	managers map[string]*lock.Manager
+}
+
+// NewAirlock returns a new Airlock service, sharing a single etcd client across
+// all configured groups and initializing their semaphores.
+func NewAirlock(ctx context.Context, settings config.Settings) (*Airlock, error) {
+	client, err := lock.NewClient(settings.EtcdEndpoints, settings.ClientCertPubPath, settings.ClientCertKeyPath, settings.EtcdTxnTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	managers, err := buildManagers(client, settings, nil)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	airlock := Airlock{
+		client:   client,
+		settings: settings,
+		managers: managers,
+	}
+	airlock.initGroups(ctx, settings, managers)
+
+	return &airlock, nil
+}
+
+// Close releases the shared etcd client.
+func (a *Airlock) Close() error {
+	if a == nil || a.client == nil {
+		return nil
+	}
+
+	return a.client.Close()
+}
+
+// Reload atomically replaces the runtime configuration, initializing semaphores
+// for new groups and refreshing configuration metrics.
+//
+// Listener and etcd client settings are bound at startup, and changing them
+// requires a restart.
+func (a *Airlock) Reload(ctx context.Context, settings config.Settings) error {
+	if a == nil {
+		return errors.New("nil Airlock")
+	}
+
+	previous := a.currentSettings()
+	if restartRequired(previous, settings) {
+		logrus.Warn("configuration reload, listener and etcd settings changes require a restart")
+	}
+
+	a.mu.RLock()
+	managers, err := buildManagers(a.client, settings, a.managers)
+	a.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	a.initGroups(ctx, settings, managers)
+
+	a.mu.Lock()
+	a.settings = settings
+	a.managers = managers
+	a.mu.Unlock()
+
+	updateConfigMetrics(settings)
+	for group := range previous.LockGroups {
+		if _, ok := settings.LockGroups[group]; !ok {
+			databaseLocksGauge.DeleteLabelValues(group)
+			databaseSlotsGauge.DeleteLabelValues(group)
+			databaseWeightGauge.DeleteLabelValues(group)
+			groupMembersGauge.DeleteLabelValues(group)
+			databaseOldestHolderGauge.DeleteLabelValues(group)
+			pausedGauge.DeleteLabelValues(group)
+			databaseWaitersGauge.DeletePartialMatch(prometheus.Labels{"group": group})
+		}
+	}
+	logrus.WithFields(logrus.Fields{
+		"groups": len(settings.LockGroups),
+	}).Info("configuration reloaded")
+
+	return nil
 }
 
 // RegisterMetrics registers all server-related metrics.
@@ -53,35 +194,246 @@ func (a *Airlock) RegisterMetrics() error {
 	collectors := []prometheus.Collector{
 		configGroupsGauge,
 		configSlotsGauge,
+		configSlotsPercentGauge,
+		groupMembersGauge,
 		databaseLocksGauge,
 		databaseSlotsGauge,
+		databaseWeightGauge,
+		databaseWaitersGauge,
+		databaseOldestHolderGauge,
+		holdDurationHistogram,
+		windowOpenGauge,
+		blackoutGauge,
+		pausedGauge,
+		grantsCounter,
+		expiredLocksCounter,
+		authFailuresCounter,
 	}
+	collectors = append(collectors, lock.Collectors()...)
 	for _, collector := range collectors {
 		if err := prometheus.Register(collector); err != nil {
 			return err
 		}
 	}
 
-	configGroupsGauge.Set(float64(len(a.LockGroups)))
-	for group, maxSlots := range a.LockGroups {
-		configSlotsGauge.WithLabelValues(group).Set(float64(maxSlots))
-	}
+	updateConfigMetrics(a.currentSettings())
 
 	return nil
 }
 
+// InFlight returns the number of lock requests currently being served.
+func (a *Airlock) InFlight() int64 {
+	if a == nil {
+		return 0
+	}
+
+	return atomic.LoadInt64(&a.inFlight)
+}
+
+// currentSettings returns a snapshot of the current runtime configuration.
+func (a *Airlock) currentSettings() config.Settings {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return a.settings
+}
+
+// buildManagers returns lock managers for all configured groups, re-using
+// existing managers for groups whose slots are unchanged.
+func buildManagers(client *clientv3.Client, settings config.Settings, existing map[string]*lock.Manager) (map[string]*lock.Manager, error) {
+	managers := make(map[string]*lock.Manager, len(settings.LockGroups))
+	for group, groupSettings := range settings.LockGroups {
+		slots := groupSettings.EffectiveSlots(0)
+		if manager, ok := existing[group]; ok && manager.Slots() == slots {
+			managers[group] = manager
+			continue
+		}
+		manager, err := lock.NewManager(client, group, slots)
+		if err != nil {
+			return nil, err
+		}
+		managers[group] = manager
+	}
+
+	return managers, nil
+}
+
+// initGroups initializes all group semaphores in etcd.
+//
+// Failures are not fatal, as initialization is retried on first use of each group.
+func (a *Airlock) initGroups(ctx context.Context, settings config.Settings, managers map[string]*lock.Manager) {
+	for group, manager := range managers {
+		innerCtx, cancel := context.WithTimeout(ctx, settings.EtcdTxnTimeout)
+		err := manager.EnsureInit(innerCtx)
+		cancel()
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"group":  group,
+				"reason": err.Error(),
+			}).Warn("semaphore initialization failed")
+		}
+	}
+}
+
+// groupManager returns the lock manager for `group`, ensuring its semaphore is initialized.
+func (a *Airlock) groupManager(ctx context.Context, group string) (*lock.Manager, error) {
+	a.mu.RLock()
+	manager, ok := a.managers[group]
+	a.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no lock manager for group %q", group)
+	}
+	if err := manager.EnsureInit(ctx); err != nil {
+		return nil, err
+	}
+
+	return manager, nil
+}
+
+// groupManagers returns the lock managers for all `groups`, ensuring their semaphores are initialized.
+func (a *Airlock) groupManagers(ctx context.Context, groups []string) ([]*lock.Manager, error) {
+	managers := make([]*lock.Manager, 0, len(groups))
+	for _, group := range groups {
+		manager, err := a.groupManager(ctx, group)
+		if err != nil {
+			return nil, err
+		}
+		managers = append(managers, manager)
+	}
+
+	return managers, nil
+}
+
+// updateConfigMetrics exposes configuration details as metrics.
+func updateConfigMetrics(settings config.Settings) {
+	configGroupsGauge.Set(float64(len(settings.LockGroups)))
+	configSlotsGauge.Reset()
+	configSlotsPercentGauge.Reset()
+	for group, groupSettings := range settings.LockGroups {
+		if groupSettings.SlotsPercent > 0 {
+			configSlotsPercentGauge.WithLabelValues(group).Set(float64(groupSettings.SlotsPercent))
+			continue
+		}
+		configSlotsGauge.WithLabelValues(group).Set(float64(groupSettings.Slots))
+	}
+	updateMaintenanceMetrics(settings, time.Now())
+}
+
+// recordMembers registers a contact of kind `event` from node `id` in the registry of all `groups`.
+func recordMembers(ctx context.Context, groups []string, managers []*lock.Manager, id string, event lock.MemberEvent) {
+	for i, manager := range managers {
+		recordMember(ctx, manager, groups[i], id, event)
+	}
+}
+
+// observeHoldDuration records the duration of a released lease.
+func observeHoldDuration(group string, lease lock.Lease, releasedAt time.Time) {
+	holdDurationHistogram.WithLabelValues(group).Observe(releasedAt.Sub(lease.AcquiredAt).Seconds())
+}
+
+// recordMember registers a contact of kind `event` from node `id` in the group registry.
+//
+// Failures are only logged, as the registry is not needed to serve the request.
+func recordMember(ctx context.Context, manager *lock.Manager, group string, id string, event lock.MemberEvent) {
+	if err := manager.RecordMember(ctx, id, time.Now(), event); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"group":  group,
+			"id":     id,
+			"reason": err.Error(),
+		}).Warn("failed to record group member")
+	}
+}
+
+// countMembers returns the number of known members of a group, ignoring members
+// which have not been seen for longer than the configured timeout.
+//
+// Stale members are kept in the registry, with their reboot history.
+func countMembers(ctx context.Context, settings config.Settings, group string, manager *lock.Manager) (uint64, error) {
+	members, err := manager.Members(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	known := len(members) - len(lock.StaleMembers(members, time.Now(), settings.MemberTimeout))
+	groupMembersGauge.WithLabelValues(group).Set(float64(known))
+
+	return uint64(known), nil
+}
+
+// updateSemaphoreMetrics exposes the database state of a group semaphore as metrics.
+func updateSemaphoreMetrics(settings config.Settings, group string, semaphore *lock.Semaphore) {
+	if semaphore == nil {
+		return
+	}
+
+	databaseLocksGauge.WithLabelValues(group).Set(float64(len(semaphore.Holders)))
+	databaseSlotsGauge.WithLabelValues(group).Set(float64(semaphore.TotalSlots))
+	databaseWeightGauge.WithLabelValues(group).Set(float64(semaphore.UsedWeight()))
+	now := time.Now()
+	databaseOldestHolderGauge.WithLabelValues(group).Set(semaphore.OldestHolderAge(now).Seconds())
+	paused := 0.0
+	if semaphore.ActivePause(now) != nil {
+		paused = 1.0
+	}
+	pausedGauge.WithLabelValues(group).Set(paused)
+
+	waiters := map[string]int{config.DefaultPriorityClass: 0}
+	for _, class := range settings.PriorityClasses {
+		waiters[class.Name] = 0
+	}
+	for _, waiter := range semaphore.Waiters {
+		waiters[settings.PriorityClassFor(waiter.ID).Name]++
+	}
+	databaseWaitersGauge.DeletePartialMatch(prometheus.Labels{"group": group})
+	for class, count := range waiters {
+		databaseWaitersGauge.WithLabelValues(group, class).Set(float64(count))
+	}
+}
+
+// restartRequired returns whether any setting bound at startup differs.
+func restartRequired(previous config.Settings, next config.Settings) bool {
+	listenersChanged := previous.ServiceAddress != next.ServiceAddress ||
+		previous.ServicePort != next.ServicePort ||
+		previous.ServiceTLS != next.ServiceTLS ||
+		previous.ServiceCertPath != next.ServiceCertPath ||
+		previous.ServiceKeyPath != next.ServiceKeyPath ||
+		previous.ServiceClientCAPath != next.ServiceClientCAPath ||
+		previous.StatusEnabled != next.StatusEnabled ||
+		previous.StatusAddress != next.StatusAddress ||
+		previous.StatusPort != next.StatusPort ||
+		previous.StatusTLS != next.StatusTLS ||
+		previous.StatusCertPath != next.StatusCertPath ||
+		previous.StatusKeyPath != next.StatusKeyPath ||
+		previous.StatusClientCAPath != next.StatusClientCAPath ||
+		previous.AdminEnabled != next.AdminEnabled ||
+		previous.AdminAddress != next.AdminAddress ||
+		previous.AdminPort != next.AdminPort ||
+		previous.AdminTLS != next.AdminTLS ||
+		previous.AdminCertPath != next.AdminCertPath ||
+		previous.AdminKeyPath != next.AdminKeyPath ||
+		previous.AdminClientCAPath != next.AdminClientCAPath
+	etcdChanged := !reflect.DeepEqual(previous.EtcdEndpoints, next.EtcdEndpoints) ||
+		previous.ClientCertPubPath != next.ClientCertPubPath ||
+		previous.ClientCertKeyPath != next.ClientCertKeyPath
+
+	return listenersChanged || etcdChanged
+}
+
 // RunConsistencyChecker runs a continuous checker for consistency between configuration
 // and remote state.
 func (a *Airlock) RunConsistencyChecker(ctx context.Context) {
 	for {
-		for group, maxSlots := range a.LockGroups {
-			a.checkConsistency(ctx, group, maxSlots)
+		settings := a.currentSettings()
+		updateMaintenanceMetrics(settings, time.Now())
+		for group, groupSettings := range settings.LockGroups {
+			a.checkConsistency(ctx, settings, group, groupSettings)
 		}
 
 		pause := time.NewTimer(time.Minute)
 		select {
 		case <-ctx.Done():
-			break
+			pause.Stop()
+			return
 		case <-pause.C:
 			continue
 		}
@@ -89,51 +441,91 @@ func (a *Airlock) RunConsistencyChecker(ctx context.Context) {
 }
 
 // checkConsistencytakes takes care of polling etcd, exposing the shared state as metrics,
-// and warning if it detects a mismatch with the service configuration.
-func (a *Airlock) checkConsistency(ctx context.Context, group string, maxSlots uint64) {
+// expiring overdue locks, and warning if it detects a mismatch with the service configuration.
+func (a *Airlock) checkConsistency(ctx context.Context, settings config.Settings, group string, groupSettings config.GroupSettings) {
 	if a == nil {
 		logrus.Error("consistency check, nil Airlock")
 		return
 	}
 
-	innerCtx, cancel := context.WithTimeout(ctx, a.EtcdTxnTimeout)
+	innerCtx, cancel := context.WithTimeout(ctx, settings.EtcdTxnTimeout)
 	defer cancel()
 
-	// TODO(lucab): re-arrange so that the manager can be re-used.
-	manager, err := lock.NewManager(innerCtx, a.EtcdEndpoints, a.ClientCertPubPath, a.ClientCertKeyPath, a.EtcdTxnTimeout, group, maxSlots)
+	manager, err := a.groupManager(innerCtx, group)
 	if err != nil {
 		logrus.WithFields(logrus.Fields{
 			"reason": err.Error(),
-		}).Warn("consistency check, manager creation failed")
+		}).Warn("consistency check, semaphore initialization failed")
 		return
 	}
-	defer manager.Close()
 
-	semaphore, err := manager.FetchSemaphore(innerCtx)
+	now := time.Now()
+	semaphore, expired, err := manager.ExpireHolders(innerCtx, now, groupSettings.MaxHold)
 	if err != nil {
 		logrus.WithFields(logrus.Fields{
 			"reason": err.Error(),
-		}).Warn("consistency check, semaphore fetch failed")
+		}).Warn("consistency check, semaphore expiry failed")
 		return
 	}
+	for id, lease := range expired {
+		logrus.WithFields(logrus.Fields{
+			"group": group,
+			"id":    id,
+		}).Warn("lock lease expired, slot released")
+		observeHoldDuration(group, lease, now)
+	}
+	expiredLocksCounter.WithLabelValues(group).Add(float64(len(expired)))
 
-	// Update metrics.
-	databaseLocksGauge.WithLabelValues(group).Set(float64(len(semaphore.Holders)))
-	databaseSlotsGauge.WithLabelValues(group).Set(float64(semaphore.TotalSlots))
+	// Percentage-based slots always follow the known group membership.
+	slots := groupSettings.Slots
+	reconcile := settings.ReconcileSlots
+	if groupSettings.SlotsPercent > 0 {
+		members, err := countMembers(innerCtx, settings, group, manager)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"group":  group,
+				"reason": err.Error(),
+			}).Warn("consistency check, group members lookup failed")
+			updateSemaphoreMetrics(settings, group, semaphore)
+			return
+		}
+		slots = groupSettings.EffectiveSlots(members)
+		reconcile = true
+	}
+
+	if reconcile && semaphore.TotalSlots != slots {
+		reconciled, previous, err := manager.ReconcileSlots(innerCtx, slots)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"group":  group,
+				"reason": err.Error(),
+			}).Warn("consistency check, semaphore slots reconciliation failed")
+		} else {
+			logrus.WithFields(logrus.Fields{
+				"group": group,
+				"new":   reconciled.TotalSlots,
+				"old":   previous,
+			}).Info("semaphore slots reconciled with configuration")
+			semaphore = reconciled
+		}
+	}
+
+	updateSemaphoreMetrics(settings, group, semaphore)
 
 	// Log any inconsistencies.
-	if semaphore.TotalSlots != maxSlots {
+	if semaphore.TotalSlots != slots {
 		logrus.WithFields(logrus.Fields{
-			"config":   maxSlots,
+			"config":   slots,
 			"database": semaphore.TotalSlots,
 			"group":    group,
 		}).Warn("semaphore max slots consistency check failed")
 	}
-	if semaphore.TotalSlots < uint64(len(semaphore.Holders)) {
+	if semaphore.TotalSlots < semaphore.UsedWeight() && len(semaphore.Holders) > 1 {
 		logrus.WithFields(logrus.Fields{
 			"group":  group,
 			"holder": len(semaphore.Holders),
 			"slots":  semaphore.TotalSlots,
+			"weight": semaphore.UsedWeight(),
 		}).Warn("semaphore locks consistency check failed")
 	}
 }
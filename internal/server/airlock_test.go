@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/coreos/airlock/internal/config"
+	"github.com/coreos/airlock/internal/lock"
+)
+
+// newTestAirlock returns an Airlock service with an etcd client which never
+// connects, so that semaphore initialization fails quickly.
+func newTestAirlock(t *testing.T, settings config.Settings) *Airlock {
+	t.Helper()
+
+	client, err := lock.NewClient([]string{"127.0.0.1:1"}, "", "", settings.EtcdTxnTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	managers, err := buildManagers(client, settings, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Airlock{
+		client:   client,
+		settings: settings,
+		managers: managers,
+	}
+}
+
+func TestReload(t *testing.T) {
+	settings := config.Settings{
+		EtcdTxnTimeout: 10 * time.Millisecond,
+		LockGroups: map[string]config.GroupSettings{
+			"workers":     {Slots: 2},
+			"controllers": {Slots: 1},
+			"legacy":      {Slots: 1},
+		},
+	}
+	airlock := newTestAirlock(t, settings)
+	previous := airlock.managers
+
+	for group := range settings.LockGroups {
+		databaseLocksGauge.WithLabelValues(group).Set(1)
+		pausedGauge.WithLabelValues(group).Set(0)
+		databaseWaitersGauge.WithLabelValues(group, "default").Set(1)
+	}
+
+	next := config.Settings{
+		EtcdTxnTimeout: 10 * time.Millisecond,
+		LockGroups: map[string]config.GroupSettings{
+			"workers":     {Slots: 2},
+			"controllers": {Slots: 3},
+			"edge":        {Slots: 1},
+		},
+	}
+	if err := airlock.Reload(context.Background(), next); err != nil {
+		t.Fatal(err)
+	}
+
+	// Managers are only replaced when slots change.
+	managers := airlock.managers
+	if managers["workers"] != previous["workers"] {
+		t.Error("unchanged group manager replaced")
+	}
+	if managers["controllers"] == previous["controllers"] || managers["controllers"].Slots() != 3 {
+		t.Errorf("changed group manager not replaced: %v", managers["controllers"])
+	}
+	if managers["edge"] == nil || managers["legacy"] != nil {
+		t.Errorf("unexpected managers: %v", managers)
+	}
+	if len(airlock.currentSettings().LockGroups) != 3 {
+		t.Errorf("settings not replaced: %v", airlock.currentSettings())
+	}
+
+	// Metrics of removed groups are dropped, others are kept.
+	for _, collector := range []*prometheus.GaugeVec{databaseLocksGauge, pausedGauge} {
+		if collector.DeleteLabelValues("legacy") {
+			t.Error("metrics of removed group not deleted")
+		}
+		if !collector.DeleteLabelValues("workers") {
+			t.Error("metrics of kept group deleted")
+		}
+	}
+	if count := databaseWaitersGauge.DeletePartialMatch(prometheus.Labels{"group": "legacy"}); count != 0 {
+		t.Errorf("%d waiters metrics of removed group not deleted", count)
+	}
+}
+
+func TestRestartRequired(t *testing.T) {
+	base := config.Settings{
+		ServiceAddress: "0.0.0.0",
+		ServicePort:    3333,
+		StatusEnabled:  true,
+		StatusPort:     2222,
+		AdminEnabled:   true,
+		AdminPort:      3334,
+		EtcdEndpoints:  []string{"https://etcd-1:2379"},
+		LockGroups: map[string]config.GroupSettings{
+			"workers": {Slots: 1},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		mutate   func(*config.Settings)
+		expected bool
+	}{
+		{"unchanged", func(s *config.Settings) {}, false},
+		{"groups", func(s *config.Settings) {
+			s.LockGroups = map[string]config.GroupSettings{"workers": {Slots: 3}}
+		}, false},
+		{"waiter timeout", func(s *config.Settings) { s.WaiterTimeout = time.Minute }, false},
+		{"service port", func(s *config.Settings) { s.ServicePort = 4444 }, true},
+		{"service TLS", func(s *config.Settings) { s.ServiceTLS = true }, true},
+		{"status disabled", func(s *config.Settings) { s.StatusEnabled = false }, true},
+		{"admin address", func(s *config.Settings) { s.AdminAddress = "127.0.0.1" }, true},
+		{"admin client CA", func(s *config.Settings) { s.AdminClientCAPath = "/etc/airlock/ca.crt" }, true},
+		{"etcd endpoints", func(s *config.Settings) { s.EtcdEndpoints = []string{"https://etcd-2:2379"} }, true},
+		{"etcd client cert", func(s *config.Settings) { s.ClientCertPubPath = "/etc/airlock/etcd.crt" }, true},
+	}
+	for _, tt := range tests {
+		next := base
+		next.EtcdEndpoints = append([]string{}, base.EtcdEndpoints...)
+		tt.mutate(&next)
+		if changed := restartRequired(base, next); changed != tt.expected {
+			t.Errorf("%s: expected %t, got %t", tt.name, tt.expected, changed)
+		}
+	}
+}
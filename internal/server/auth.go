@@ -0,0 +1,155 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/coreos/airlock/internal/config"
+	"github.com/coreos/airlock/internal/herrors"
+)
+
+const (
+	// SignatureHeader is the request header carrying an HMAC-SHA256 signature of
+	// the timestamp and body, as `<timestamp>.<body>`.
+	SignatureHeader = "X-Airlock-Signature"
+	// TimestampHeader is the request header carrying the signature time, in Unix seconds.
+	TimestampHeader = "X-Airlock-Timestamp"
+	// signaturePrefix is the prefix of hex-encoded signatures in SignatureHeader.
+	signaturePrefix = "sha256="
+	// signatureMaxAge is the maximum difference between the signature time and
+	// the server clock, beyond which signed requests are rejected as replays.
+	signatureMaxAge = 5 * time.Minute
+)
+
+var (
+	// authFailuresCounter holds a metrics counter with per-group authentication failures.
+	authFailuresCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "airlock_auth_failures_total",
+		Help: "Total number of failed client authentications, per group.",
+	}, []string{"group"})
+)
+
+// authenticate checks that the client is authorized to act on behalf of `identity`.
+func authenticate(req *http.Request, identity *NodeIdentity, settings config.Settings) *herrors.HTTPError {
+	if settings.ServiceClientIDCheck || settings.ServiceClientGroupCheck {
+		if err := verifyClientCert(req, identity, settings.ServiceClientIDCheck, settings.ServiceClientGroupCheck); err != nil {
+			msg := fmt.Sprintf("client certificate not valid for identity: %s", err.Error())
+			logrus.WithFields(logrus.Fields{
+				"group": identity.Group,
+				"id":    identity.ID,
+			}).Errorln(msg)
+			herr := herrors.New(403, "unauthorized_client_identity", msg)
+			return &herr
+		}
+	}
+
+	groupSettings, ok := settings.LockGroups[identity.Group]
+	if ok && len(groupSettings.Secret) > 0 {
+		if err := verifySecret(req, groupSettings.Secret, time.Now()); err != nil {
+			authFailuresCounter.WithLabelValues(identity.Group).Inc()
+			msg := fmt.Sprintf("failed to authenticate client: %s", err.Error())
+			logrus.WithFields(logrus.Fields{
+				"group": identity.Group,
+				"id":    identity.ID,
+			}).Errorln(msg)
+			herr := herrors.New(401, "unauthenticated_client", msg)
+			return &herr
+		}
+	}
+
+	return nil
+}
+
+// verifySecret checks that the request carries either a bearer token equal to
+// `secret`, or a valid HMAC-SHA256 signature of its timestamp and body keyed with
+// `secret`, signed within `signatureMaxAge` of `now`.
+func verifySecret(req *http.Request, secret []byte, now time.Time) error {
+	if req == nil {
+		return errors.New("nil request")
+	}
+
+	if auth := req.Header.Get("Authorization"); auth != "" {
+		token := strings.TrimPrefix(auth, "Bearer ")
+		if token == auth {
+			return errors.New("unsupported authorization scheme")
+		}
+		if subtle.ConstantTimeCompare([]byte(token), secret) != 1 {
+			return errors.New("invalid bearer token")
+		}
+		return nil
+	}
+
+	if signature := req.Header.Get(SignatureHeader); signature != "" {
+		if !strings.HasPrefix(signature, signaturePrefix) {
+			return errors.New("unsupported signature algorithm")
+		}
+		received, err := hex.DecodeString(strings.TrimPrefix(signature, signaturePrefix))
+		if err != nil {
+			return fmt.Errorf("malformed signature: %w", err)
+		}
+		timestamp := req.Header.Get(TimestampHeader)
+		seconds, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return fmt.Errorf("missing or malformed %s header", TimestampHeader)
+		}
+		if age := now.Sub(time.Unix(seconds, 0)); age > signatureMaxAge || age < -signatureMaxAge {
+			return fmt.Errorf("stale signature timestamp %d", seconds)
+		}
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return err
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(timestamp + "."))
+		mac.Write(body)
+		if !hmac.Equal(received, mac.Sum(nil)) {
+			return errors.New("invalid body signature")
+		}
+		return nil
+	}
+
+	return errors.New("missing bearer token or body signature")
+}
+
+// verifyClientCert checks that the verified client certificate matches the node
+// ID (as its CN or a DNS SAN), and/or the node group (as one of its OUs).
+func verifyClientCert(req *http.Request, identity *NodeIdentity, checkID bool, checkGroup bool) error {
+	if req == nil || identity == nil {
+		return errors.New("nil request or identity")
+	}
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return errors.New("no verified client certificate")
+	}
+	cert := req.TLS.VerifiedChains[0][0]
+
+	if checkID && cert.Subject.CommonName != identity.ID && !contains(cert.DNSNames, identity.ID) {
+		return fmt.Errorf("ID %q not in certificate CN or SANs", identity.ID)
+	}
+	if checkGroup && !contains(cert.Subject.OrganizationalUnit, identity.Group) {
+		return fmt.Errorf("group %q not in certificate OUs", identity.Group)
+	}
+
+	return nil
+}
+
+// contains returns whether `list` contains `value`.
+func contains(list []string, value string) bool {
+	for _, entry := range list {
+		if entry == value {
+			return true
+		}
+	}
+	return false
+}
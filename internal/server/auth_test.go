@@ -0,0 +1,170 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/airlock/internal/config"
+)
+
+func TestVerifyClientCert(t *testing.T) {
+	cert := &x509.Certificate{
+		Subject: pkix.Name{
+			CommonName:         "node-a",
+			OrganizationalUnit: []string{"workers"},
+		},
+		DNSNames: []string{"node-a.example.com"},
+	}
+	req := &http.Request{
+		TLS: &tls.ConnectionState{
+			VerifiedChains: [][]*x509.Certificate{{cert}},
+		},
+	}
+
+	tests := []struct {
+		identity NodeIdentity
+		valid    bool
+	}{
+		{NodeIdentity{Group: "workers", ID: "node-a"}, true},
+		{NodeIdentity{Group: "workers", ID: "node-a.example.com"}, true},
+		{NodeIdentity{Group: "workers", ID: "node-b"}, false},
+		{NodeIdentity{Group: "controllers", ID: "node-a"}, false},
+	}
+	for _, tt := range tests {
+		err := verifyClientCert(req, &tt.identity, true, true)
+		if tt.valid && err != nil {
+			t.Errorf("unexpected error for %v: %s", tt.identity, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("unexpected success for %v", tt.identity)
+		}
+	}
+
+	if err := verifyClientCert(&http.Request{}, &tests[0].identity, true, false); err == nil {
+		t.Error("unexpected success without client certificate")
+	}
+}
+
+// sign returns the signature of `body` at Unix time `timestamp`.
+func sign(secret []byte, timestamp int64, body string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(fmt.Sprintf("%d.%s", timestamp, body)))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifySecret(t *testing.T) {
+	secret := []byte("s3cr3t")
+	body := `{"client_params":{"group":"default","id":"a"}}`
+	now := time.Unix(1600000000, 0)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	signature := sign(secret, now.Unix(), body)
+
+	tests := []struct {
+		headers map[string]string
+		valid   bool
+	}{
+		{map[string]string{"Authorization": "Bearer s3cr3t"}, true},
+		{map[string]string{"Authorization": "Bearer wrong"}, false},
+		{map[string]string{"Authorization": "Basic s3cr3t"}, false},
+		{map[string]string{SignatureHeader: signature, TimestampHeader: timestamp}, true},
+		{map[string]string{SignatureHeader: sign(secret, now.Unix()-60, body), TimestampHeader: strconv.FormatInt(now.Unix()-60, 10)}, true},
+		// The timestamp is signed, and cannot be replaced.
+		{map[string]string{SignatureHeader: signature, TimestampHeader: strconv.FormatInt(now.Unix()+1, 10)}, false},
+		// Stale (replayed) signatures are rejected.
+		{map[string]string{SignatureHeader: sign(secret, now.Unix()-600, body), TimestampHeader: strconv.FormatInt(now.Unix()-600, 10)}, false},
+		{map[string]string{SignatureHeader: sign(secret, now.Unix()+600, body), TimestampHeader: strconv.FormatInt(now.Unix()+600, 10)}, false},
+		{map[string]string{SignatureHeader: signature}, false},
+		{map[string]string{SignatureHeader: signature, TimestampHeader: "yesterday"}, false},
+		{map[string]string{SignatureHeader: "sha256=00", TimestampHeader: timestamp}, false},
+		{map[string]string{SignatureHeader: "md5=00", TimestampHeader: timestamp}, false},
+		{map[string]string{}, false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", PreRebootEndpoint, strings.NewReader(body))
+		for header, value := range tt.headers {
+			req.Header.Set(header, value)
+		}
+		err := verifySecret(req, secret, now)
+		if tt.valid && err != nil {
+			t.Errorf("unexpected error for %v: %s", tt.headers, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("unexpected success for %v", tt.headers)
+		}
+	}
+}
+
+func TestAuthenticateGroups(t *testing.T) {
+	secret := []byte("s3cr3t")
+	settings := config.Settings{
+		LockGroups: map[string]config.GroupSettings{
+			"default": {Slots: 1},
+			"secure":  {Slots: 1, Secret: secret},
+			"racks":   {Slots: 1, Parent: "default"},
+		},
+		GroupMemberships: []config.GroupMembership{
+			{Groups: []string{"secure"}, IDs: []string{"db-*"}},
+		},
+	}
+	body := `{"client_params":{"group":"secure","id":"db-1"}}`
+	now := time.Now().Unix()
+
+	tests := []struct {
+		identity NodeIdentity
+		header   string
+		value    string
+		valid    bool
+	}{
+		// Only the requested group is authenticated, not parents or memberships.
+		{NodeIdentity{Group: "default", ID: "db-1"}, "", "", true},
+		{NodeIdentity{Group: "racks", ID: "db-1"}, "", "", true},
+		{NodeIdentity{Group: "secure", ID: "db-1"}, "", "", false},
+		{NodeIdentity{Group: "secure", ID: "db-1"}, "Authorization", "Bearer wrong", false},
+		{NodeIdentity{Group: "secure", ID: "db-1"}, "Authorization", "Bearer s3cr3t", true},
+		{NodeIdentity{Group: "secure", ID: "web-1"}, SignatureHeader, sign(secret, now, body), true},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", PreRebootEndpoint, strings.NewReader(body))
+		if tt.header != "" {
+			req.Header.Set(tt.header, tt.value)
+			req.Header.Set(TimestampHeader, strconv.FormatInt(now, 10))
+		}
+		herr := authenticate(req, &tt.identity, settings)
+		if tt.valid && herr != nil {
+			t.Errorf("unexpected error for %v with %s %q: %v", tt.identity, tt.header, tt.value, herr)
+		}
+		if !tt.valid && (herr == nil || herr.Code != 401) {
+			t.Errorf("expected 401 for %v with %s %q, got %v", tt.identity, tt.header, tt.value, herr)
+		}
+	}
+
+	// The client certificate only needs the OU of the requested group.
+	cert := &x509.Certificate{
+		Subject: pkix.Name{
+			CommonName:         "db-1",
+			OrganizationalUnit: []string{"racks"},
+		},
+	}
+	req := httptest.NewRequest("POST", PreRebootEndpoint, strings.NewReader(body))
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	settings.ServiceClientGroupCheck = true
+	identity := NodeIdentity{Group: "racks", ID: "db-1"}
+	if herr := authenticate(req, &identity, settings); herr != nil {
+		t.Errorf("unexpected error with requested group OU: %v", herr)
+	}
+	identity = NodeIdentity{Group: "default", ID: "db-1"}
+	if herr := authenticate(req, &identity, settings); herr == nil || herr.Code != 403 {
+		t.Errorf("expected 403 for certificate without group OU, got %v", herr)
+	}
+}
@@ -0,0 +1,27 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/coreos/airlock/internal/herrors"
+	"github.com/coreos/airlock/internal/lock"
+)
+
+// lockHTTPError converts a lock error into an HTTP error, distinguishing
+// busy semaphores from backend failures. Unknown errors use `kind` with code 500.
+func lockHTTPError(err error, kind string) herrors.HTTPError {
+	switch {
+	case errors.Is(err, lock.ErrSlotsFull):
+		return herrors.New(423, "slots_full", err.Error())
+	case errors.Is(err, lock.ErrPaused):
+		return herrors.New(423, "group_paused", err.Error())
+	case errors.Is(err, lock.ErrConflict):
+		return herrors.New(409, "lock_conflict", err.Error())
+	case errors.Is(err, lock.ErrUnavailable):
+		return herrors.New(503, "backend_unavailable", err.Error())
+	case errors.Is(err, lock.ErrCorrupt):
+		return herrors.New(500, "corrupt_semaphore", err.Error())
+	default:
+		return herrors.New(500, kind, err.Error())
+	}
+}
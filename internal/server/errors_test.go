@@ -0,0 +1,34 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/coreos/airlock/internal/lock"
+)
+
+func TestLockHTTPError(t *testing.T) {
+	tests := []struct {
+		err  error
+		code int
+		kind string
+	}{
+		{fmt.Errorf("%w: all 2 semaphore slots currently locked", lock.ErrSlotsFull), 423, "slots_full"},
+		{fmt.Errorf("%w: incident", lock.ErrPaused), 423, "group_paused"},
+		{lock.ErrConflict, 409, "lock_conflict"},
+		{fmt.Errorf("%w: context deadline exceeded", lock.ErrUnavailable), 503, "backend_unavailable"},
+		{fmt.Errorf("%w: empty semaphore value", lock.ErrCorrupt), 500, "corrupt_semaphore"},
+		{errors.New("other"), 500, "failed_lock"},
+	}
+
+	for _, tt := range tests {
+		herr := lockHTTPError(tt.err, "failed_lock")
+		if herr.Code != tt.code || herr.Kind != tt.kind {
+			t.Errorf("unexpected error for %q: %d %s", tt.err, herr.Code, herr.Kind)
+		}
+		if herr.Value != tt.err.Error() {
+			t.Errorf("unexpected value: %s", herr.Value)
+		}
+	}
+}
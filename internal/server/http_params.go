@@ -1,11 +1,18 @@
 package server
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
+	"io"
 	"net/http"
 )
 
+const (
+	// maxBodySize is the maximum size of a request body, in bytes.
+	maxBodySize = 64 * 1024
+)
+
 // HTTPParams contains all parameters for a remote lock request.
 type HTTPParams struct {
 	ClientParams Params `json:"client_params"`
@@ -29,9 +36,15 @@ func validateIdentity(req *http.Request) (*NodeIdentity, error) {
 		return nil, errors.New("wrong 'fleet-lock-protocol' header")
 	}
 
-	decoder := json.NewDecoder(req.Body)
+	// Keep the raw body around, as it may be needed for authentication.
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxBodySize))
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+
 	var input HTTPParams
-	if err := decoder.Decode(&input); err != nil {
+	if err := json.Unmarshal(body, &input); err != nil {
 		return nil, err
 	}
 
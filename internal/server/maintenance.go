@@ -0,0 +1,69 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/coreos/airlock/internal/config"
+	"github.com/coreos/airlock/internal/herrors"
+)
+
+var (
+	// windowOpenGauge holds a metrics gauge with per-group maintenance window status.
+	windowOpenGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "airlock_group_maintenance_window_open",
+		Help: "Whether the maintenance window of each group is currently open (1) or closed (0).",
+	}, []string{"group"})
+	// blackoutGauge holds a metrics gauge with per-group active blackouts.
+	blackoutGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "airlock_group_blackout_active",
+		Help: "Active blackout (from blackout calendars) of each group, by name.",
+	}, []string{"group", "blackout"})
+)
+
+// maintenanceError returns an error if any of `groups` is outside its
+// maintenance windows or in a blackout at time `now`.
+//
+// Groups in which the node already holds a slot, as reported by `holds` (if not
+// nil) for the index of a refusing group, are skipped: as for paused groups,
+// holders polling again are confirmed their slot.
+func maintenanceError(settings config.Settings, groups []string, now time.Time, holds func(int) bool) *herrors.HTTPError {
+	for i, group := range groups {
+		groupSettings := settings.LockGroups[group]
+		var herr herrors.HTTPError
+		if schedule := groupSettings.Maintenance; !schedule.Open(now) {
+			msg := fmt.Sprintf("maintenance window for group %q is closed, next opening at %s", group, schedule.NextOpening(now).Format(time.RFC3339))
+			herr = herrors.New(423, "maintenance_window_closed", msg)
+		} else if blackout := groupSettings.ActiveBlackout(now); blackout != nil {
+			msg := fmt.Sprintf("group %q is in blackout %q until %s", group, blackout.Name, blackout.End.Format(time.RFC3339))
+			herr = herrors.New(423, "blackout_active", msg)
+		} else {
+			continue
+		}
+		if holds != nil && holds(i) {
+			continue
+		}
+		return &herr
+	}
+
+	return nil
+}
+
+// updateMaintenanceMetrics exposes the current maintenance window and blackout
+// status of all groups as metrics.
+func updateMaintenanceMetrics(settings config.Settings, now time.Time) {
+	windowOpenGauge.Reset()
+	blackoutGauge.Reset()
+	for group, groupSettings := range settings.LockGroups {
+		open := 0.0
+		if groupSettings.Maintenance.Open(now) {
+			open = 1.0
+		}
+		windowOpenGauge.WithLabelValues(group).Set(open)
+		if blackout := groupSettings.ActiveBlackout(now); blackout != nil {
+			blackoutGauge.WithLabelValues(group, blackout.Name).Set(1)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/airlock/internal/config"
+	"github.com/coreos/airlock/internal/maintenance"
+)
+
+func TestMaintenanceError(t *testing.T) {
+	window, err := maintenance.ParseWindow(nil, "02:00", "05:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	schedule, err := maintenance.NewSchedule("UTC", []maintenance.Window{window})
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := config.Settings{
+		LockGroups: map[string]config.GroupSettings{
+			"always": {},
+			"nightly": {
+				Maintenance: schedule,
+			},
+		},
+	}
+	night := time.Date(2021, time.March, 1, 3, 0, 0, 0, time.UTC)
+	day := time.Date(2021, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	if herr := maintenanceError(settings, []string{"always", "nightly"}, night, nil); herr != nil {
+		t.Errorf("unexpected error: %v", herr)
+	}
+	if herr := maintenanceError(settings, []string{"always"}, day, nil); herr != nil {
+		t.Errorf("unexpected error: %v", herr)
+	}
+	herr := maintenanceError(settings, []string{"always", "nightly"}, day, nil)
+	if herr == nil {
+		t.Fatal("expected error")
+	}
+	if herr.Code != 423 || herr.Kind != "maintenance_window_closed" {
+		t.Errorf("unexpected error: %v", herr)
+	}
+
+	// Holders polling again are not refused.
+	holds := func(i int) bool { return i == 1 }
+	if herr := maintenanceError(settings, []string{"always", "nightly"}, day, holds); herr != nil {
+		t.Errorf("unexpected error for holder: %v", herr)
+	}
+}
+
+func TestBlackoutError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "freeze.ics")
+	content := "BEGIN:VCALENDAR\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"SUMMARY:Launch freeze\r\n" +
+		"DTSTART:20210301T000000Z\r\n" +
+		"DTEND:20210302T000000Z\r\n" +
+		"END:VEVENT\r\n" +
+		"END:VCALENDAR\r\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	calendar, err := maintenance.NewCalendar(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := config.Settings{
+		LockGroups: map[string]config.GroupSettings{
+			"frozen": {
+				Blackouts: []*maintenance.Calendar{calendar},
+			},
+		},
+	}
+
+	during := time.Date(2021, time.March, 1, 12, 0, 0, 0, time.UTC)
+	herr := maintenanceError(settings, []string{"frozen"}, during, nil)
+	if herr == nil {
+		t.Fatal("expected error")
+	}
+	if herr.Code != 423 || herr.Kind != "blackout_active" || !strings.Contains(herr.Value, "Launch freeze") {
+		t.Errorf("unexpected error: %v", herr)
+	}
+	if herr := maintenanceError(settings, []string{"frozen"}, during.Add(24*time.Hour), nil); herr != nil {
+		t.Errorf("unexpected error: %v", herr)
+	}
+	holds := func(int) bool { return true }
+	if herr := maintenanceError(settings, []string{"frozen"}, during, holds); herr != nil {
+		t.Errorf("unexpected error for holder: %v", herr)
+	}
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/coreos/airlock/internal/config"
+	"github.com/coreos/airlock/internal/herrors"
+	"github.com/coreos/airlock/internal/lock"
+)
+
+const (
+	// NodesEndpoint is the endpoint for the node registry.
+	NodesEndpoint = "/nodes"
+)
+
+// NodesReport contains registry details for all known nodes.
+type NodesReport struct {
+	Nodes []lock.Node `json:"nodes"`
+}
+
+// Nodes is the handler for the `/nodes` endpoint.
+//
+// Results can be restricted to a single group with the `group` query parameter,
+// and to nodes without a completed reboot within a duration (e.g. `720h`) with
+// the `not_rebooted_for` query parameter.
+func (a *Airlock) Nodes() http.Handler {
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		report, herr := a.nodesHandler(req)
+		if herr != nil {
+			http.Error(w, herr.ToJSON(), herr.Code)
+			return
+		}
+
+		out, err := json.Marshal(report)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(out)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+// nodesHandler contains node registry handling logic
+func (a *Airlock) nodesHandler(req *http.Request) (*NodesReport, *herrors.HTTPError) {
+	if a == nil {
+		return nil, &errNilAirlockServer
+	}
+	settings := a.currentSettings()
+
+	query := req.URL.Query()
+	groups := sortedGroups(settings)
+	if group := query.Get("group"); group != "" {
+		if _, ok := settings.LockGroups[group]; !ok {
+			herr := herrors.New(400, "unknown_group", fmt.Sprintf("unknown group %q", group))
+			return nil, &herr
+		}
+		groups = []string{group}
+	}
+	var notRebootedFor time.Duration
+	if value := query.Get("not_rebooted_for"); value != "" {
+		var err error
+		notRebootedFor, err = time.ParseDuration(value)
+		if err != nil {
+			herr := herrors.New(400, "invalid_query", fmt.Sprintf("invalid not_rebooted_for: %s", err.Error()))
+			return nil, &herr
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), settings.EtcdTxnTimeout)
+	defer cancel()
+
+	report := NodesReport{Nodes: []lock.Node{}}
+	for _, group := range groups {
+		nodes, err := a.groupNodes(ctx, group)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"group":  group,
+				"reason": err.Error(),
+			}).Warn("node registry lookup failed")
+			herr := lockHTTPError(err, "failed_nodes_lookup")
+			return nil, &herr
+		}
+		report.Nodes = append(report.Nodes, lock.FilterNotRebooted(nodes, time.Now(), notRebootedFor)...)
+	}
+
+	return &report, nil
+}
+
+// groupNodes returns registry details for all known nodes of `group`.
+func (a *Airlock) groupNodes(ctx context.Context, group string) ([]lock.Node, error) {
+	manager, err := a.groupManager(ctx, group)
+	if err != nil {
+		return nil, err
+	}
+
+	return manager.Nodes(ctx)
+}
+
+// sortedGroups returns the names of all configured groups, sorted.
+func sortedGroups(settings config.Settings) []string {
+	groups := make([]string, 0, len(settings.LockGroups))
+	for group := range settings.LockGroups {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	return groups
+}
@@ -2,8 +2,11 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"sync/atomic"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
@@ -29,6 +32,9 @@ func (a *Airlock) PreReboot() http.Handler {
 	prometheus.MustRegister(preRebootIncomingReqs)
 
 	handler := func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt64(&a.inFlight, 1)
+		defer atomic.AddInt64(&a.inFlight, -1)
+
 		if herr := a.preRebootHandler(req); herr != nil {
 			http.Error(w, herr.ToJSON(), herr.Code)
 		} else {
@@ -47,6 +53,7 @@ func (a *Airlock) preRebootHandler(req *http.Request) *herrors.HTTPError {
 	if a == nil {
 		return &errNilAirlockServer
 	}
+	settings := a.currentSettings()
 
 	nodeIdentity, err := validateIdentity(req)
 	if err != nil {
@@ -55,12 +62,15 @@ func (a *Airlock) preRebootHandler(req *http.Request) *herrors.HTTPError {
 		herr := herrors.New(400, "invalid_client_identity", msg)
 		return &herr
 	}
+	if herr := authenticate(req, nodeIdentity, settings); herr != nil {
+		return herr
+	}
 	logrus.WithFields(logrus.Fields{
 		"group": nodeIdentity.Group,
 		"id":    nodeIdentity.ID,
 	}).Debug("processing client pre-reboot request")
 
-	slots, ok := a.LockGroups[nodeIdentity.Group]
+	groupSettings, ok := settings.LockGroups[nodeIdentity.Group]
 	if !ok {
 		msg := fmt.Sprintf("unknown group %q", nodeIdentity.Group)
 		logrus.Errorln(msg)
@@ -68,32 +78,68 @@ func (a *Airlock) preRebootHandler(req *http.Request) *herrors.HTTPError {
 		return &herr
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), a.EtcdTxnTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), settings.EtcdTxnTimeout)
 	defer cancel()
-	lockManager, err := lock.NewManager(ctx, a.EtcdEndpoints, a.ClientCertPubPath, a.ClientCertKeyPath, a.EtcdTxnTimeout, nodeIdentity.Group, slots)
+	groups := settings.GroupsFor(nodeIdentity.ID, nodeIdentity.Group)
+	lockManagers, err := a.groupManagers(ctx, groups)
 	if err != nil {
 		msg := fmt.Sprintf("failed to initialize semaphore manager: %s", err.Error())
 		logrus.Errorln(msg)
-		herr := herrors.New(500, "failed_sem_init", msg)
+		herr := lockHTTPError(err, "failed_sem_init")
 		return &herr
 	}
-	defer lockManager.Close()
 
-	sem, err := lockManager.RecursiveLock(ctx, nodeIdentity.ID)
+	holds := func(i int) bool {
+		sem, err := lockManagers[i].FetchSemaphore(ctx)
+		return err == nil && sem.NodeState(nodeIdentity.ID) == lock.NodeLocked
+	}
+	priorityClass := settings.PriorityClassFor(nodeIdentity.ID)
+	opts := lock.LockOptions{
+		MaxHold:       groupSettings.MaxHold,
+		WaiterTimeout: settings.WaiterTimeout,
+		Priority:      priorityClass.Priority,
+		PriorityAging: settings.PriorityAging,
+		Weight:        settings.WeightFor(nodeIdentity.ID),
+	}
+	if herr := maintenanceError(settings, groups, time.Now(), holds); herr != nil {
+		// Keep the queue position, so that waiters are not pruned while closed.
+		if _, err := lock.WaitAll(ctx, lockManagers, nodeIdentity.ID, opts); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"group":  nodeIdentity.Group,
+				"id":     nodeIdentity.ID,
+				"reason": err.Error(),
+			}).Warn("failed to refresh wait queue")
+		}
+		recordMembers(ctx, groups, lockManagers, nodeIdentity.ID, lock.MemberSeen)
+		logrus.Infoln(herr.Value)
+		return herr
+	}
+
+	sems, err := lock.LockAll(ctx, lockManagers, nodeIdentity.ID, opts)
 	if err != nil {
+		recordMembers(ctx, groups, lockManagers, nodeIdentity.ID, lock.MemberSeen)
+		herr := lockHTTPError(err, "failed_lock")
 		msg := fmt.Sprintf("failed to lock semaphore: %s", err.Error())
-		logrus.Errorln(msg)
-		herr := herrors.New(500, "failed_lock", err.Error())
+		if errors.Is(err, lock.ErrSlotsFull) {
+			logrus.Infoln(msg)
+		} else {
+			logrus.Errorln(msg)
+		}
 		return &herr
 	}
 
+	recordMembers(ctx, groups, lockManagers, nodeIdentity.ID, lock.MemberGranted)
+
 	// Update metrics.
-	databaseLocksGauge.WithLabelValues(nodeIdentity.Group).Set(float64(len(sem.Holders)))
-	databaseSlotsGauge.WithLabelValues(nodeIdentity.Group).Set(float64(sem.TotalSlots))
+	grantsCounter.WithLabelValues(nodeIdentity.Group, priorityClass.Name).Inc()
+	for i, sem := range sems {
+		updateSemaphoreMetrics(settings, groups[i], sem)
+	}
 
 	logrus.WithFields(logrus.Fields{
 		"group": nodeIdentity.Group,
 		"id":    nodeIdentity.ID,
+		"class": priorityClass.Name,
 	}).Debug("givin green-flag to pre-reboot request")
 
 	return nil
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/coreos/airlock/internal/config"
+)
+
+const (
+	// ReadinessEndpoint is the endpoint for readiness probes.
+	ReadinessEndpoint = "/readyz"
+)
+
+// Readiness contains the readiness state of the service.
+type Readiness struct {
+	Ready  bool                      `json:"ready"`
+	Groups map[string]GroupReadiness `json:"groups"`
+}
+
+// GroupReadiness contains the readiness state of a single group.
+type GroupReadiness struct {
+	Ready   bool   `json:"ready"`
+	Error   string `json:"error,omitempty"`
+	Slots   uint64 `json:"slots"`
+	Holders int    `json:"holders"`
+}
+
+// Readiness is the handler for the `/readyz` endpoint.
+//
+// The service is ready when all group semaphores are initialized and readable
+// from etcd within the transaction timeout.
+func (a *Airlock) Readiness() http.Handler {
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		settings := a.currentSettings()
+		ctx, cancel := context.WithTimeout(req.Context(), settings.EtcdTxnTimeout)
+		defer cancel()
+
+		readiness := a.checkReadiness(ctx, settings)
+		code := http.StatusOK
+		if !readiness.Ready {
+			code = http.StatusServiceUnavailable
+		}
+
+		out, err := json.Marshal(readiness)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		_, _ = w.Write(out)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+// checkReadiness concurrently checks all group semaphores.
+func (a *Airlock) checkReadiness(ctx context.Context, settings config.Settings) Readiness {
+	readiness := Readiness{
+		Ready:  true,
+		Groups: make(map[string]GroupReadiness, len(settings.LockGroups)),
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for group := range settings.LockGroups {
+		wg.Add(1)
+		go func(group string) {
+			defer wg.Done()
+			state := a.checkGroupReadiness(ctx, group)
+
+			mu.Lock()
+			defer mu.Unlock()
+			readiness.Groups[group] = state
+			if !state.Ready {
+				readiness.Ready = false
+			}
+		}(group)
+	}
+	wg.Wait()
+
+	return readiness
+}
+
+// checkGroupReadiness checks that the semaphore for `group` is initialized and readable.
+func (a *Airlock) checkGroupReadiness(ctx context.Context, group string) GroupReadiness {
+	manager, err := a.groupManager(ctx, group)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"group":  group,
+			"reason": err.Error(),
+		}).Warn("readiness check, semaphore initialization failed")
+		return GroupReadiness{Error: err.Error()}
+	}
+
+	semaphore, err := manager.FetchSemaphore(ctx)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"group":  group,
+			"reason": err.Error(),
+		}).Warn("readiness check, semaphore fetch failed")
+		return GroupReadiness{Error: err.Error()}
+	}
+
+	return GroupReadiness{
+		Ready:   true,
+		Slots:   semaphore.TotalSlots,
+		Holders: len(semaphore.Holders),
+	}
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/coreos/airlock/internal/config"
+)
+
+func TestReadiness(t *testing.T) {
+	tests := []struct {
+		groups map[string]config.GroupSettings
+		code   int
+		ready  bool
+	}{
+		{map[string]config.GroupSettings{}, http.StatusOK, true},
+		// Semaphores cannot be read from the unreachable etcd.
+		{map[string]config.GroupSettings{"workers": {Slots: 1}, "controllers": {Slots: 1}}, http.StatusServiceUnavailable, false},
+	}
+
+	for _, tt := range tests {
+		airlock := newTestAirlock(t, config.Settings{
+			EtcdTxnTimeout: 10 * time.Millisecond,
+			LockGroups:     tt.groups,
+		})
+		rec := httptest.NewRecorder()
+		airlock.Readiness().ServeHTTP(rec, httptest.NewRequest("GET", ReadinessEndpoint, nil))
+
+		if rec.Code != tt.code {
+			t.Errorf("expected status %d, got %d", tt.code, rec.Code)
+		}
+		if contentType := rec.Header().Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("unexpected content type %q", contentType)
+		}
+
+		var body struct {
+			Ready  *bool `json:"ready"`
+			Groups map[string]struct {
+				Ready   *bool   `json:"ready"`
+				Error   string  `json:"error"`
+				Slots   *uint64 `json:"slots"`
+				Holders *int    `json:"holders"`
+			} `json:"groups"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("invalid body %q: %s", rec.Body.String(), err)
+		}
+		if body.Ready == nil || *body.Ready != tt.ready || body.Groups == nil || len(body.Groups) != len(tt.groups) {
+			t.Errorf("unexpected body: %s", rec.Body.String())
+		}
+		for group, state := range body.Groups {
+			if state.Ready == nil || *state.Ready || state.Error == "" || state.Slots == nil || state.Holders == nil {
+				t.Errorf("unexpected state for group %q: %s", group, rec.Body.String())
+			}
+		}
+	}
+}
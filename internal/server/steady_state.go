@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"sync/atomic"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
@@ -29,6 +31,9 @@ func (a *Airlock) SteadyState() http.Handler {
 	prometheus.MustRegister(steadyStateIncomingReqs)
 
 	handler := func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt64(&a.inFlight, 1)
+		defer atomic.AddInt64(&a.inFlight, -1)
+
 		if herr := a.steadyStateHandler(req); herr != nil {
 			http.Error(w, herr.ToJSON(), herr.Code)
 		} else {
@@ -47,6 +52,7 @@ func (a *Airlock) steadyStateHandler(req *http.Request) *herrors.HTTPError {
 	if a == nil {
 		return &errNilAirlockServer
 	}
+	settings := a.currentSettings()
 
 	nodeIdentity, err := validateIdentity(req)
 	if err != nil {
@@ -55,41 +61,50 @@ func (a *Airlock) steadyStateHandler(req *http.Request) *herrors.HTTPError {
 		herr := herrors.New(400, "invalid_client_identity", msg)
 		return &herr
 	}
+	if herr := authenticate(req, nodeIdentity, settings); herr != nil {
+		return herr
+	}
 	logrus.WithFields(logrus.Fields{
 		"group": nodeIdentity.Group,
 		"id":    nodeIdentity.ID,
 	}).Debug("processing client steady-state report")
 
-	slots, ok := a.LockGroups[nodeIdentity.Group]
-	if !ok {
+	if _, ok := settings.LockGroups[nodeIdentity.Group]; !ok {
 		msg := fmt.Sprintf("unknown group %q", nodeIdentity.Group)
 		logrus.Errorln(msg)
 		herr := herrors.New(400, "unknown_group", msg)
 		return &herr
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), a.EtcdTxnTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), settings.EtcdTxnTimeout)
 	defer cancel()
-	lockManager, err := lock.NewManager(ctx, a.EtcdEndpoints, a.ClientCertPubPath, a.ClientCertKeyPath, a.EtcdTxnTimeout, nodeIdentity.Group, slots)
+	groups := settings.GroupsFor(nodeIdentity.ID, nodeIdentity.Group)
+	lockManagers, err := a.groupManagers(ctx, groups)
 	if err != nil {
 		msg := fmt.Sprintf("failed to initialize semaphore manager: %s", err.Error())
 		logrus.Errorln(msg)
-		herr := herrors.New(500, "failed_sem_init", msg)
+		herr := lockHTTPError(err, "failed_sem_init")
 		return &herr
 	}
-	defer lockManager.Close()
 
-	sem, err := lockManager.UnlockIfHeld(ctx, nodeIdentity.ID)
+	sems, released, err := lock.UnlockAll(ctx, lockManagers, nodeIdentity.ID)
 	if err != nil {
+		recordMembers(ctx, groups, lockManagers, nodeIdentity.ID, lock.MemberSeen)
 		msg := fmt.Sprintf("failed to release any semaphore lock: %s", err.Error())
 		logrus.Errorln(msg)
-		herr := herrors.New(500, "failed_lock", err.Error())
+		herr := lockHTTPError(err, "failed_lock")
 		return &herr
 	}
 
+	recordMembers(ctx, groups, lockManagers, nodeIdentity.ID, lock.MemberSteady)
+
 	// Update metrics.
-	databaseLocksGauge.WithLabelValues(nodeIdentity.Group).Set(float64(len(sem.Holders)))
-	databaseSlotsGauge.WithLabelValues(nodeIdentity.Group).Set(float64(sem.TotalSlots))
+	if released != nil {
+		observeHoldDuration(nodeIdentity.Group, *released, time.Now())
+	}
+	for i, sem := range sems {
+		updateSemaphoreMetrics(settings, groups[i], sem)
+	}
 
 	logrus.WithFields(logrus.Fields{
 		"group": nodeIdentity.Group,
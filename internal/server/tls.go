@@ -0,0 +1,150 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// certReloader holds TLS server material loaded from files, reloading it
+// whenever any of the files is modified on disk.
+type certReloader struct {
+	certPath     string
+	keyPath      string
+	clientCAPath string
+
+	mu        sync.Mutex
+	modTimes  []time.Time
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+}
+
+// NewTLSConfig returns a TLS server configuration using the certificate and key
+// at the given paths. If `clientCAPath` is not empty, clients are required to
+// present a certificate signed by one of the CAs it contains.
+//
+// All files are checked on each handshake and reloaded on change, so that
+// certificates can be rotated without restarting the service.
+func NewTLSConfig(certPath string, keyPath string, clientCAPath string) (*tls.Config, error) {
+	if certPath == "" || keyPath == "" {
+		return nil, errors.New("missing TLS certificate or key path")
+	}
+
+	reloader := certReloader{
+		certPath:     certPath,
+		keyPath:      keyPath,
+		clientCAPath: clientCAPath,
+	}
+	if _, err := reloader.reloadIfChanged(); err != nil {
+		return nil, err
+	}
+
+	tlsConfig := tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &reloader.current().Certificates[0], nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return reloader.current(), nil
+		},
+	}
+
+	return &tlsConfig, nil
+}
+
+// current returns the TLS configuration for a new connection, reloading files if needed.
+func (r *certReloader) current() *tls.Config {
+	changed, err := r.reloadIfChanged()
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"cert":   r.certPath,
+			"reason": err.Error(),
+		}).Warn("TLS reload failed, keeping previous certificates")
+	} else if changed {
+		logrus.WithFields(logrus.Fields{
+			"cert": r.certPath,
+		}).Info("TLS certificates reloaded")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tlsConfig := tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{*r.cert},
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+	if r.clientCAs != nil {
+		tlsConfig.ClientCAs = r.clientCAs
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return &tlsConfig
+}
+
+// reloadIfChanged (re-)loads all files if their modification times changed,
+// returning whether a reload happened.
+func (r *certReloader) reloadIfChanged() (bool, error) {
+	paths := []string{r.certPath, r.keyPath}
+	if r.clientCAPath != "" {
+		paths = append(paths, r.clientCAPath)
+	}
+
+	modTimes := make([]time.Time, 0, len(paths))
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return false, err
+		}
+		modTimes = append(modTimes, info.ModTime())
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.cert != nil && equalTimes(modTimes, r.modTimes) {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certPath, r.keyPath)
+	if err != nil {
+		return false, err
+	}
+
+	var clientCAs *x509.CertPool
+	if r.clientCAPath != "" {
+		caPEM, err := os.ReadFile(r.clientCAPath)
+		if err != nil {
+			return false, err
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(caPEM) {
+			return false, fmt.Errorf("no valid CA certificates in %q", r.clientCAPath)
+		}
+	}
+
+	r.cert = &cert
+	r.clientCAs = clientCAs
+	r.modTimes = modTimes
+
+	return true, nil
+}
+
+// equalTimes returns whether two lists of timestamps are identical.
+func equalTimes(a []time.Time, b []time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert writes a self-signed certificate with serial `serial` and its key
+// to `certPath` and `keyPath`, with modification time `modTime`.
+func writeCert(t *testing.T, certPath string, keyPath string, serial int64, modTime time.Time) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: "airlock"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	for path, content := range map[string][]byte{certPath: certPEM, keyPath: keyPEM} {
+		if err := os.WriteFile(path, content, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// handshake connects a client to a server using `serverConfig`, returning the
+// certificate served and the handshake error.
+func handshake(t *testing.T, serverConfig *tls.Config) (*x509.Certificate, error) {
+	t.Helper()
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	server := tls.Server(serverConn, serverConfig)
+	go func() {
+		_ = server.Handshake()
+		server.Close()
+	}()
+
+	client := tls.Client(clientConn, &tls.Config{InsecureSkipVerify: true})
+	if err := client.Handshake(); err != nil {
+		return nil, err
+	}
+	// Client certificate failures are only reported by the server after the handshake.
+	if _, err := client.Read(make([]byte, 1)); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return client.ConnectionState().PeerCertificates[0], nil
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "service.crt")
+	keyPath := filepath.Join(dir, "service.key")
+	start := time.Now().Add(-time.Hour)
+
+	if _, err := NewTLSConfig(certPath, keyPath, ""); err == nil {
+		t.Error("expected error for missing files")
+	}
+
+	writeCert(t, certPath, keyPath, 1, start)
+	tlsConfig, err := NewTLSConfig(certPath, keyPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := handshake(t, tlsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.SerialNumber.Int64() != 1 {
+		t.Errorf("unexpected certificate served: %d", cert.SerialNumber)
+	}
+
+	// Rotated files are picked up on the next handshake.
+	writeCert(t, certPath, keyPath, 2, start.Add(time.Minute))
+	cert, err = handshake(t, tlsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.SerialNumber.Int64() != 2 {
+		t.Errorf("rotated certificate not served: %d", cert.SerialNumber)
+	}
+
+	// A broken pair keeps the previous certificate.
+	if err := os.WriteFile(keyPath, []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(keyPath, start.Add(2*time.Minute), start.Add(2*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	cert, err = handshake(t, tlsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.SerialNumber.Int64() != 2 {
+		t.Errorf("previous certificate not kept: %d", cert.SerialNumber)
+	}
+}
+
+func TestNewTLSConfigClientCA(t *testing.T) {
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "service.crt")
+	keyPath := filepath.Join(dir, "service.key")
+	writeCert(t, certPath, keyPath, 1, time.Now())
+
+	// The self-signed certificate doubles as client CA.
+	tlsConfig, err := NewTLSConfig(certPath, keyPath, certPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := tlsConfig.GetConfigForClient(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ClientAuth != tls.RequireAndVerifyClientCert || config.ClientCAs == nil {
+		t.Errorf("client certificates not required: %v", config.ClientAuth)
+	}
+	if _, err := handshake(t, tlsConfig); err == nil {
+		t.Error("expected handshake without client certificate to fail")
+	}
+
+	if _, err := NewTLSConfig(certPath, keyPath, keyPath); err == nil {
+		t.Error("expected error for invalid client CA")
+	}
+}
@@ -0,0 +1,23 @@
+package status
+
+import (
+	"net/http"
+)
+
+const (
+	// HealthEndpoint is the endpoint for liveness probes.
+	HealthEndpoint = "/healthz"
+)
+
+// Health is the handler for the `/healthz` endpoint.
+//
+// It only reports that the process is up and serving requests.
+func Health() http.Handler {
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("{\"status\":\"ok\"}\n"))
+	}
+
+	return http.HandlerFunc(handler)
+}